@@ -0,0 +1,95 @@
+package accounting
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default range of a report when no start is given
+const defaultRange = 30 * 24 * time.Hour
+
+type accountingResponse struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Usage []Record  `json:"usage"`
+}
+
+// AccountingHandler reports the usage recorded in l for the range given by
+// the start and end query parameters, as RFC 3339 timestamps. The report is
+// JSON unless format=csv is given, and can be limited to a single user with
+// the user parameter.
+func AccountingHandler(l *Ledger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		end := time.Now()
+		if value := query.Get("end"); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			end = t
+		}
+
+		start := end.Add(-defaultRange)
+		if value := query.Get("start"); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			start = t
+		}
+
+		if !start.Before(end) {
+			http.Error(w, "start must be before end", http.StatusBadRequest)
+			return
+		}
+
+		usage, err := l.Usage(start, end, query.Get("user"))
+		if err != nil {
+			slog.Error("unable to read accounting ledger", "err", err)
+			http.Error(w, "unable to read accounting ledger", http.StatusInternalServerError)
+			return
+		}
+
+		slices.SortFunc(usage, func(a, b Record) int {
+			return cmp.Or(cmp.Compare(a.Username, b.Username), cmp.Compare(a.Proc, b.Proc))
+		})
+
+		switch strings.ToLower(query.Get("format")) {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(accountingResponse{Start: start, End: end, Usage: usage})
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			writeCSV(w, usage)
+		default:
+			http.Error(w, "invalid format, expected json or csv", http.StatusBadRequest)
+		}
+	}
+}
+
+func writeCSV(w http.ResponseWriter, usage []Record) {
+	c := csv.NewWriter(w)
+	c.Write([]string{"username", "proc", "start", "end", "cpu_seconds", "memory_byte_seconds"})
+	for _, r := range usage {
+		c.Write([]string{
+			r.Username,
+			r.Proc,
+			r.Start.Format(time.RFC3339),
+			r.End.Format(time.RFC3339),
+			strconv.FormatFloat(r.CPUSeconds, 'f', -1, 64),
+			strconv.FormatFloat(r.MemoryByteSeconds, 'f', -1, 64),
+		})
+	}
+	c.Flush()
+}
@@ -0,0 +1,128 @@
+package accounting
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"slices"
+	"testing"
+	"time"
+)
+
+// handlerFixture returns a ledger holding usage of two users over two
+// buckets, on the 1st and the 2nd of January 2024.
+func handlerFixture(t *testing.T) *Ledger {
+	t.Helper()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	records := []Record{
+		{Start: day(1), End: day(1).Add(time.Hour), Username: "u0", Proc: "python3", CPUSeconds: 1},
+		{Start: day(1), End: day(1).Add(time.Hour), Username: "u1", Proc: "R", CPUSeconds: 2},
+		{Start: day(2), End: day(2).Add(time.Hour), Username: "u0", Proc: "python3", CPUSeconds: 4},
+		{Start: day(2), End: day(2).Add(time.Hour), Username: "u1", Proc: "R", CPUSeconds: 8},
+	}
+
+	file := path.Join(t.TempDir(), "ledger")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		enc.Encode(r)
+	}
+	f.Close()
+
+	l, err := NewLedger(file, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func get(t *testing.T, l *Ledger, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	AccountingHandler(l)(w, httptest.NewRequest(http.MethodGet, "/accounting?"+query, nil))
+	return w
+}
+
+func TestAccountingHandlerJSON(t *testing.T) {
+	l := handlerFixture(t)
+
+	tests := []struct {
+		query string
+		want  map[string]float64
+	}{
+		{"start=2024-01-01T00:00:00Z&end=2024-01-03T00:00:00Z", map[string]float64{"u0": 5, "u1": 10}},
+		{"start=2024-01-02T00:00:00Z&end=2024-01-03T00:00:00Z", map[string]float64{"u0": 4, "u1": 8}},
+		{"start=2024-01-01T00:30:00Z&end=2024-01-01T00:45:00Z", map[string]float64{"u0": 1, "u1": 2}},
+		{"start=2024-01-01T01:00:00Z&end=2024-01-02T00:00:00Z", map[string]float64{}},
+		{"start=2024-01-01T00:00:00Z&end=2024-01-03T00:00:00Z&user=u1", map[string]float64{"u1": 10}},
+	}
+	for _, tt := range tests {
+		w := get(t, l, tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200", tt.query, w.Code)
+		}
+		var resp accountingResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, r := range resp.Usage {
+			got[r.Username] += r.CPUSeconds
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got usage %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for user, cpu := range tt.want {
+			if got[user] != cpu {
+				t.Errorf("%s: got %v cpu seconds for %s, want %v", tt.query, got[user], user, cpu)
+			}
+		}
+	}
+}
+
+func TestAccountingHandlerCSV(t *testing.T) {
+	l := handlerFixture(t)
+
+	w := get(t, l, "start=2024-01-02T00:00:00Z&end=2024-01-03T00:00:00Z&format=csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("got content type %q", got)
+	}
+
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"username", "proc", "start", "end", "cpu_seconds", "memory_byte_seconds"},
+		{"u0", "python3", "2024-01-02T00:00:00Z", "2024-01-02T01:00:00Z", "4", "0"},
+		{"u1", "R", "2024-01-02T00:00:00Z", "2024-01-02T01:00:00Z", "8", "0"},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+}
+
+func TestAccountingHandlerInvalid(t *testing.T) {
+	l := handlerFixture(t)
+
+	for _, query := range []string{
+		"start=yesterday",
+		"end=2024-01-01",
+		"start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z",
+		"format=xml",
+	} {
+		if w := get(t, l, query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+)
+
+const (
+	// how often the open bucket is written to disk, bounding what is lost
+	// if the warden exits before the bucket closes
+	checkpointInterval = time.Minute
+
+	// groups not observed for this long are forgotten, and the next
+	// observation of them only sets a baseline
+	staleAfter = 10 * time.Minute
+)
+
+// Record is the usage of one process name of one user over a bucket.
+type Record struct {
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	Username          string    `json:"username"`
+	Proc              string    `json:"proc"`
+	CPUSeconds        float64   `json:"cpu_seconds"`
+	MemoryByteSeconds float64   `json:"memory_byte_seconds"`
+}
+
+type recordKey struct {
+	username string
+	proc     string
+}
+
+// bucket is the usage accumulated since start, which is written to the
+// ledger once it spans the bucket size.
+type bucket struct {
+	Start   time.Time `json:"start"`
+	Records []Record  `json:"records"`
+}
+
+// baseline is the last observed usage of a cgroup, from which the usage
+// between observations is derived.
+type baseline struct {
+	time time.Time
+	cpu  map[string]float64
+}
+
+// Ledger persists per user, per process usage to an append only file of JSON
+// records, one per line. Usage is accumulated in memory into buckets of a
+// fixed size, which are appended to the file once they close. If retention
+// is set, records older than it are compacted out of the file as buckets
+// close.
+type Ledger struct {
+	path       string
+	size       time.Duration
+	retention  time.Duration
+	open       map[recordKey]*Record
+	start      time.Time
+	baselines  map[string]baseline
+	checkpoint time.Time
+	mutex      sync.Mutex
+
+	// guards the ledger file, so it is not read while being appended to.
+	// It is always taken after mutex, never before.
+	file sync.RWMutex
+}
+
+// NewLedger opens the ledger at path, resuming the bucket that was open when
+// the warden last exited, if any. Records that ended more than retention ago
+// are removed; a retention of zero keeps every record.
+func NewLedger(path string, size, retention time.Duration) (*Ledger, error) {
+	l := &Ledger{
+		path:      path,
+		size:      size,
+		retention: retention,
+		open:      make(map[recordKey]*Record),
+		start:     time.Now().Truncate(size),
+		baselines: make(map[string]baseline),
+		mutex:     sync.Mutex{},
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	err = l.resume()
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.compact(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Ledger) openPath() string {
+	return l.path + ".open"
+}
+
+func (l *Ledger) resume() error {
+	buf, err := os.ReadFile(l.openPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var b bucket
+	err = json.Unmarshal(buf, &b)
+	if err != nil {
+		return fmt.Errorf("unable to parse open bucket '%s': %w", l.openPath(), err)
+	}
+
+	if b.Start.Equal(l.start) {
+		for _, r := range b.Records {
+			l.open[recordKey{r.Username, r.Proc}] = &r
+		}
+		return nil
+	}
+
+	err = l.append(b.Records)
+	if err != nil {
+		return err
+	}
+
+	// the old bucket is now in the ledger, so it must not be appended
+	// again if the warden exits before the next checkpoint
+	return l.save()
+}
+
+// Observe accumulates the usage between this and the previous observation
+// of each cgroup. The first observation of a cgroup only sets the baseline,
+// as usage before it may already have been recorded. Concurrent collections
+// may finish out of order, so observations no newer than the baseline are
+// dropped.
+func (l *Ledger) Observe(usage []metrics.GroupUsage) {
+	defer l.mutex.Unlock()
+	l.mutex.Lock()
+
+	now := time.Now()
+	if !now.Before(l.start.Add(l.size)) {
+		l.rollover(now)
+	}
+
+	for _, u := range usage {
+		prev, seen := l.baselines[u.CGroup]
+		if seen && !u.Time.After(prev.time) {
+			continue
+		}
+		next := baseline{time: u.Time, cpu: make(map[string]float64, len(u.Processes))}
+
+		for name, p := range u.Processes {
+			next.cpu[name] = p.CPUSeconds
+			if !seen {
+				continue
+			}
+
+			// a decrease means the counter was reset, so all of it is new
+			cpu := p.CPUSeconds
+			if last, ok := prev.cpu[name]; ok && cpu >= last {
+				cpu -= last
+			}
+
+			r := l.record(u.Info.Username, name)
+			r.CPUSeconds += cpu
+			r.MemoryByteSeconds += float64(p.MemoryBytes) * u.Time.Sub(prev.time).Seconds()
+			r.End = u.Time
+		}
+
+		l.baselines[u.CGroup] = next
+	}
+
+	for cg, b := range l.baselines {
+		if now.Sub(b.time) > staleAfter {
+			delete(l.baselines, cg)
+		}
+	}
+
+	if now.Sub(l.checkpoint) > checkpointInterval {
+		err := l.save()
+		if err != nil {
+			slog.Warn("unable to checkpoint accounting ledger", "path", l.openPath(), "err", err)
+		}
+		l.checkpoint = now
+	}
+}
+
+func (l *Ledger) record(username, proc string) *Record {
+	key := recordKey{username, proc}
+	r, ok := l.open[key]
+	if !ok {
+		r = &Record{Start: l.start, Username: username, Proc: proc}
+		l.open[key] = r
+	}
+	return r
+}
+
+// rollover closes the open bucket, appending its records to the ledger.
+func (l *Ledger) rollover(now time.Time) {
+	err := l.append(l.records())
+	if err != nil {
+		slog.Error("unable to append to accounting ledger, usage will be lost", "path", l.path, "err", err)
+	}
+
+	l.open = make(map[recordKey]*Record)
+	l.start = now.Truncate(l.size)
+
+	// the closed bucket is now in the ledger, so it must not be appended
+	// again if the warden exits before the next checkpoint
+	err = l.save()
+	if err != nil {
+		slog.Warn("unable to checkpoint accounting ledger", "path", l.openPath(), "err", err)
+	}
+	l.checkpoint = now
+
+	err = l.compact(now)
+	if err != nil {
+		slog.Warn("unable to compact accounting ledger", "path", l.path, "err", err)
+	}
+}
+
+func (l *Ledger) records() []Record {
+	records := make([]Record, 0, len(l.open))
+	for _, r := range l.open {
+		records = append(records, *r)
+	}
+	return records
+}
+
+func (l *Ledger) append(records []Record) error {
+	defer l.file.Unlock()
+	l.file.Lock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		err = enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// compact atomically rewrites the ledger without the records that ended
+// more than the retention before now.
+func (l *Ledger) compact(now time.Time) error {
+	if l.retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-l.retention)
+
+	defer l.file.Unlock()
+	l.file.Lock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var kept []Record
+	dropped := 0
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r Record
+		err = dec.Decode(&r)
+		if err != nil {
+			return fmt.Errorf("unable to parse accounting ledger '%s': %w", l.path, err)
+		}
+		if r.End.Before(cutoff) {
+			dropped++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if dropped == 0 {
+		return nil
+	}
+
+	tmp := l.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, r := range kept {
+		err = enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("compacted accounting ledger", "path", l.path, "dropped", dropped)
+	return os.Rename(tmp, l.path)
+}
+
+// save atomically writes the open bucket to disk.
+func (l *Ledger) save() error {
+	buf, err := json.Marshal(bucket{Start: l.start, Records: l.records()})
+	if err != nil {
+		return err
+	}
+
+	tmp := l.openPath() + ".tmp"
+	err = os.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.openPath())
+}
+
+// Usage returns the usage recorded between start and end, summed per user
+// and process name. Buckets are included if they overlap the range. If
+// username is not empty, only usage of that user is returned.
+func (l *Ledger) Usage(start, end time.Time, username string) ([]Record, error) {
+	totals := make(map[recordKey]*Record)
+	add := func(r Record) {
+		if username != "" && r.Username != username {
+			return
+		}
+		if !r.Start.Before(end) || !r.End.After(start) {
+			return
+		}
+		key := recordKey{r.Username, r.Proc}
+		total, ok := totals[key]
+		if !ok {
+			total = &Record{Start: r.Start, End: r.End, Username: r.Username, Proc: r.Proc}
+			totals[key] = total
+		}
+		total.Start = minTime(total.Start, r.Start)
+		total.End = maxTime(total.End, r.End)
+		total.CPUSeconds += r.CPUSeconds
+		total.MemoryByteSeconds += r.MemoryByteSeconds
+	}
+
+	// the open records are copied and the file locked before mutex is
+	// released, so a bucket closing meanwhile is counted exactly once
+	l.mutex.Lock()
+	open := l.records()
+	l.file.RLock()
+	l.mutex.Unlock()
+	defer l.file.RUnlock()
+
+	for _, r := range open {
+		add(r)
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r Record
+		err = dec.Decode(&r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse accounting ledger '%s': %w", l.path, err)
+		}
+		add(r)
+	}
+
+	usage := make([]Record, 0, len(totals))
+	for _, r := range totals {
+		usage = append(usage, *r)
+	}
+	return usage, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,187 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+)
+
+const testCGroup = "/user.slice/user-1000.slice"
+
+func usage(t time.Time, cpu float64, memory uint64) []metrics.GroupUsage {
+	return []metrics.GroupUsage{{
+		CGroup: testCGroup,
+		Time:   t,
+		Info:   hierarchy.CGroupInfo{Identity: hierarchy.Identity{Username: "u0"}},
+		Processes: map[string]metrics.ProcessAggregation{
+			"python3": {CPUSeconds: cpu, MemoryBytes: memory},
+		},
+	}}
+}
+
+// ledgerRecords returns the records appended to the ledger at file.
+func ledgerRecords(t *testing.T, file string) []Record {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []Record
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r Record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// sameRecord reports whether a and b are equal, comparing times by instant
+// as they lose their location through JSON.
+func sameRecord(a, b Record) bool {
+	return a.Start.Equal(b.Start) && a.End.Equal(b.End) &&
+		a.Username == b.Username && a.Proc == b.Proc &&
+		a.CPUSeconds == b.CPUSeconds && a.MemoryByteSeconds == b.MemoryByteSeconds
+}
+
+// openBucket returns the bucket checkpointed next to the ledger at file.
+func openBucket(t *testing.T, file string) bucket {
+	t.Helper()
+	buf, err := os.ReadFile(file + ".open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bucket
+	if err := json.Unmarshal(buf, &b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLedgerRollover(t *testing.T) {
+	file := path.Join(t.TempDir(), "ledger")
+	l, err := NewLedger(file, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	l.Observe(usage(now, 10, 100))
+	l.Observe(usage(now.Add(10*time.Second), 15, 100))
+	if records := ledgerRecords(t, file); len(records) != 0 {
+		t.Fatalf("got %d records before the bucket closed, want none", len(records))
+	}
+
+	// the open bucket started an hour earlier, so it closes on the next
+	// observation
+	l.mutex.Lock()
+	closed := l.start.Add(-time.Hour)
+	l.start = closed
+	for _, r := range l.open {
+		r.Start = closed
+	}
+	l.mutex.Unlock()
+	l.Observe(nil)
+
+	records := ledgerRecords(t, file)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.Username != "u0" || r.Proc != "python3" || !r.Start.Equal(closed) {
+		t.Errorf("got %+v, want python3 of u0 starting at %v", r, closed)
+	}
+	if r.CPUSeconds != 5 || r.MemoryByteSeconds != 1000 {
+		t.Errorf("got %v cpu seconds and %v memory byte-seconds, want 5 and 1000", r.CPUSeconds, r.MemoryByteSeconds)
+	}
+
+	// the checkpoint is the new, empty bucket
+	if b := openBucket(t, file); !b.Start.Equal(closed.Add(time.Hour)) || len(b.Records) != 0 {
+		t.Errorf("got open bucket %+v after rollover, want an empty bucket after %v", b, closed)
+	}
+}
+
+func TestLedgerResume(t *testing.T) {
+	file := path.Join(t.TempDir(), "ledger")
+	current := time.Now().Truncate(time.Hour)
+	record := Record{Start: current, End: current.Add(time.Minute), Username: "u0", Proc: "python3", CPUSeconds: 5}
+
+	// the bucket is still open when the warden restarts, so it is resumed
+	// rather than appended
+	buf, _ := json.Marshal(bucket{Start: current, Records: []Record{record}})
+	if err := os.WriteFile(file+".open", buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLedger(file, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := ledgerRecords(t, file); len(records) != 0 {
+		t.Errorf("got %d records appended for a bucket still open, want none", len(records))
+	}
+	l.mutex.Lock()
+	resumed := l.records()
+	l.mutex.Unlock()
+	if len(resumed) != 1 || !sameRecord(resumed[0], record) {
+		t.Errorf("got open records %+v, want %+v", resumed, record)
+	}
+
+	// a bucket that closed while the warden was down is appended once,
+	// however many times it restarts
+	record.Start, record.End = current.Add(-time.Hour), current.Add(-time.Minute)
+	buf, _ = json.Marshal(bucket{Start: record.Start, Records: []Record{record}})
+	if err := os.WriteFile(file+".open", buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := NewLedger(file, time.Hour, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records := ledgerRecords(t, file); len(records) != 1 || !sameRecord(records[0], record) {
+		t.Errorf("got records %+v, want %+v once", records, record)
+	}
+	if b := openBucket(t, file); !b.Start.Equal(current) || len(b.Records) != 0 {
+		t.Errorf("got open bucket %+v after resuming, want an empty bucket at %v", b, current)
+	}
+}
+
+func TestLedgerRetention(t *testing.T) {
+	file := path.Join(t.TempDir(), "ledger")
+	now := time.Now().Truncate(time.Hour)
+	old := Record{Start: now.Add(-72 * time.Hour), End: now.Add(-71 * time.Hour), Username: "u0", Proc: "old"}
+	recent := Record{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour), Username: "u0", Proc: "recent"}
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	enc.Encode(old)
+	enc.Encode(recent)
+	f.Close()
+
+	if _, err := NewLedger(file, time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	if records := ledgerRecords(t, file); len(records) != 2 {
+		t.Fatalf("got %d records without retention, want 2", len(records))
+	}
+
+	if _, err := NewLedger(file, time.Hour, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if records := ledgerRecords(t, file); len(records) != 1 || !sameRecord(records[0], recent) {
+		t.Errorf("got records %+v, want only %+v", records, recent)
+	}
+}
@@ -2,16 +2,29 @@ package main
 
 import (
 	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/chpc-uofu/cgroup-warden/control"
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/webhook"
+	"github.com/containerd/cgroups/v3"
 	"github.com/containerd/cgroups/v3/cgroup2"
 )
 
 type Config struct {
 	RootCGroup    string  `env:"ROOT_CGROUP" envDefault:"/user.slice"`
+	CGroupMount   string  `env:"CGROUP_MOUNT" envDefault:"/sys/fs/cgroup"`
+	ProcPath      string  `env:"PROC_PATH" envDefault:"/proc"`
+	CGroupMode    string  `env:"CGROUP_MODE"`
 	ListenAddress string  `env:"LISTEN_ADDRESS" envDefault:":2112"`
 	Certificate   string  `env:"CERTIFICATE"`
 	PrivateKey    string  `env:"PRIVATE_KEY"`
@@ -20,6 +33,55 @@ type Config struct {
 	MetaMetrics   bool    `env:"META_METRICS" envDefault:"true"`
 	LogLevel      string  `env:"LOG_LEVEL" envDefault:"info"`
 	SwapRatio     float64 `env:"SWAP_RATIO" envDefault:"0.1"`
+
+	ControlBackends map[string]string `env:"CONTROL_BACKENDS"`
+
+	LegacyMetricNames bool `env:"LEGACY_METRIC_NAMES" envDefault:"false"`
+
+	CollectInterval time.Duration `env:"COLLECT_INTERVAL" envDefault:"0s"`
+	CollectTimeout  time.Duration `env:"COLLECT_TIMEOUT" envDefault:"10s"`
+	GroupWorkers    int           `env:"GROUP_WORKERS" envDefault:"8"`
+	ProcessWorkers  int           `env:"PROCESS_WORKERS" envDefault:"1"`
+	PSSEnabled      bool          `env:"PSS_ENABLED" envDefault:"true"`
+	PSSInterval     time.Duration `env:"PSS_INTERVAL" envDefault:"0s"`
+	LoginSessions   bool          `env:"LOGIN_SESSIONS" envDefault:"false"`
+
+	ProcNameSource       string   `env:"PROC_NAME_SOURCE" envDefault:"comm"`
+	ProcNamePatterns     []string `env:"PROC_NAME_PATTERNS" envSeparator:";"`
+	ProcNameInterpreters []string `env:"PROC_NAME_INTERPRETERS"`
+	ProcLimit            int      `env:"PROC_LIMIT" envDefault:"0"`
+	ProcLimitBy          string   `env:"PROC_LIMIT_BY" envDefault:"cpu"`
+
+	ProcRetention time.Duration `env:"PROC_RETENTION" envDefault:"1h"`
+
+	HistorySize         int           `env:"HISTORY_SIZE" envDefault:"60"`
+	AccountingPath      string        `env:"ACCOUNTING_PATH"`
+	AccountingBucket    time.Duration `env:"ACCOUNTING_BUCKET" envDefault:"1h"`
+	AccountingRetention time.Duration `env:"ACCOUNTING_RETENTION" envDefault:"0"`
+
+	EventMemoryThreshold float64 `env:"EVENT_MEMORY_THRESHOLD" envDefault:"0"`
+	EventCPUThreshold    float64 `env:"EVENT_CPU_THRESHOLD" envDefault:"0"`
+
+	FreezeStatePath string `env:"FREEZE_STATE_PATH"`
+
+	WebhookURLs       []string      `env:"WEBHOOK_URLS"`
+	WebhookEvents     []string      `env:"WEBHOOK_EVENTS" envDefault:"control.applied,memory.oom_kill"`
+	WebhookSecret     string        `env:"WEBHOOK_SECRET"`
+	WebhookQueuePath  string        `env:"WEBHOOK_QUEUE_PATH"`
+	WebhookQueueSize  int           `env:"WEBHOOK_QUEUE_SIZE" envDefault:"1000"`
+	WebhookTimeout    time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookBackoff    time.Duration `env:"WEBHOOK_BACKOFF" envDefault:"1s"`
+	WebhookMaxBackoff time.Duration `env:"WEBHOOK_MAX_BACKOFF" envDefault:"5m"`
+
+	NotifyTerminals    bool          `env:"NOTIFY_TERMINALS" envDefault:"false"`
+	NotifyFile         string        `env:"NOTIFY_FILE"`
+	NotifyTemplatePath string        `env:"NOTIFY_TEMPLATE_PATH"`
+	NotifyInterval     time.Duration `env:"NOTIFY_INTERVAL" envDefault:"15m"`
+
+	UsernameCacheTTL         time.Duration `env:"USERNAME_CACHE_TTL" envDefault:"10m"`
+	UsernameNegativeCacheTTL time.Duration `env:"USERNAME_NEGATIVE_CACHE_TTL" envDefault:"1m"`
+	UsernameLookupTimeout    time.Duration `env:"USERNAME_LOOKUP_TIMEOUT" envDefault:"2s"`
+	SupplementaryGroups      []string      `env:"SUPPLEMENTARY_GROUPS"`
 }
 
 func NewConfig() (*Config, error) {
@@ -36,6 +98,27 @@ func NewConfig() (*Config, error) {
 		return nil, fmt.Errorf("Invalid cgroup root: '%v'", c.RootCGroup)
 	}
 
+	for _, dir := range []string{c.CGroupMount, c.ProcPath} {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("Invalid mount point: '%v' is not a directory", dir)
+		}
+	}
+
+	hierarchy.MountPoint = path.Clean(c.CGroupMount)
+	hierarchy.ProcPath = path.Clean(c.ProcPath)
+
+	modes := map[string]cgroups.CGMode{
+		"":        cgroups.Unavailable,
+		"legacy":  cgroups.Legacy,
+		"hybrid":  cgroups.Hybrid,
+		"unified": cgroups.Unified,
+	}
+	mode, ok := modes[strings.ToLower(c.CGroupMode)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid cgroup mode '%s'. Options include legacy, hybrid and unified", c.CGroupMode)
+	}
+	hierarchy.ForceMode = mode
+
 	if !c.InsecureMode {
 
 		if c.Certificate == "" {
@@ -64,6 +147,146 @@ func NewConfig() (*Config, error) {
 
 	hierarchy.SwapRatio = c.SwapRatio
 
+	backends := []string{control.BackendSystemd, control.BackendCGroupFS, control.BackendBoth}
+	for property, backend := range c.ControlBackends {
+		if !slices.Contains(control.CGroupFSProperties, property) {
+			return nil, fmt.Errorf("Invalid control backend property '%s'. Options include %v", property, control.CGroupFSProperties)
+		}
+		if !slices.Contains(backends, backend) {
+			return nil, fmt.Errorf("Invalid control backend '%s' for %s. Options include %v", backend, property, backends)
+		}
+	}
+
+	control.Backends = c.ControlBackends
+
+	if c.CollectInterval < 0 {
+		return nil, fmt.Errorf("Invalid collect interval %v. Cannot be negative", c.CollectInterval)
+	}
+
+	if c.CollectTimeout < 0 {
+		return nil, fmt.Errorf("Invalid collect timeout %v. Cannot be negative", c.CollectTimeout)
+	}
+
+	metrics.CollectTimeout = c.CollectTimeout
+	metrics.LegacyMetricNames = c.LegacyMetricNames
+	if c.GroupWorkers < 0 || c.ProcessWorkers < 0 {
+		return nil, fmt.Errorf("Invalid number of workers. Cannot be negative")
+	}
+
+	metrics.GroupWorkers = c.GroupWorkers
+	metrics.ProcessWorkers = c.ProcessWorkers
+
+	if c.PSSInterval < 0 {
+		return nil, fmt.Errorf("Invalid PSS interval %v. Cannot be negative", c.PSSInterval)
+	}
+
+	metrics.PSSEnabled = c.PSSEnabled
+	metrics.PSSInterval = c.PSSInterval
+	metrics.SessionsEnabled = c.LoginSessions
+
+	sources := []string{metrics.NameSourceComm, metrics.NameSourceExe}
+	c.ProcNameSource = strings.ToLower(c.ProcNameSource)
+
+	if !slices.Contains(sources, c.ProcNameSource) {
+		return nil, fmt.Errorf("Invalid process name source. Options include %v", sources)
+	}
+
+	metrics.NameSource = c.ProcNameSource
+
+	for _, pattern := range c.ProcNamePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid process name pattern '%v': %v", pattern, err)
+		}
+		metrics.NamePatterns = append(metrics.NamePatterns, re)
+	}
+
+	for _, interpreter := range c.ProcNameInterpreters {
+		if _, err := path.Match(interpreter, ""); err != nil {
+			return nil, fmt.Errorf("Invalid interpreter pattern '%v': %v", interpreter, err)
+		}
+	}
+
+	metrics.Interpreters = c.ProcNameInterpreters
+
+	orderings := []string{metrics.LimitByCPU, metrics.LimitByMemory}
+	c.ProcLimitBy = strings.ToLower(c.ProcLimitBy)
+
+	if !slices.Contains(orderings, c.ProcLimitBy) {
+		return nil, fmt.Errorf("Invalid process limit ordering. Options include %v", orderings)
+	}
+
+	metrics.ProcessLimit = c.ProcLimit
+	metrics.LimitBy = c.ProcLimitBy
+
+	if c.ProcRetention < 0 {
+		return nil, fmt.Errorf("Invalid process retention %v. Cannot be negative", c.ProcRetention)
+	}
+
+	metrics.ProcessRetention = c.ProcRetention
+
+	metrics.HistorySize = c.HistorySize
+
+	if c.AccountingBucket <= 0 {
+		return nil, fmt.Errorf("Invalid accounting bucket %v. Must be positive", c.AccountingBucket)
+	}
+
+	if c.AccountingRetention < 0 {
+		return nil, fmt.Errorf("Invalid accounting retention %v. Cannot be negative", c.AccountingRetention)
+	}
+
+	if c.EventMemoryThreshold < 0 || c.EventCPUThreshold < 0 {
+		return nil, fmt.Errorf("Invalid event threshold. Cannot be negative")
+	}
+
+	for _, u := range c.WebhookURLs {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("Invalid webhook URL '%s'. Must be an http or https URL", u)
+		}
+	}
+
+	if c.WebhookQueueSize < 0 {
+		return nil, fmt.Errorf("Invalid webhook queue size %d. Cannot be negative", c.WebhookQueueSize)
+	}
+
+	if c.WebhookTimeout <= 0 || c.WebhookBackoff <= 0 || c.WebhookMaxBackoff < c.WebhookBackoff {
+		return nil, fmt.Errorf("Invalid webhook timeout or backoff. Must be positive, and the max backoff at least the backoff")
+	}
+
+	control.FreezeStatePath = c.FreezeStatePath
+
+	webhook.Types = c.WebhookEvents
+	webhook.Secret = c.WebhookSecret
+	webhook.QueueSize = c.WebhookQueueSize
+	webhook.Timeout = c.WebhookTimeout
+	webhook.Backoff = c.WebhookBackoff
+	webhook.MaxBackoff = c.WebhookMaxBackoff
+
+	if c.NotifyFile != "" && (path.IsAbs(c.NotifyFile) || path.Base(c.NotifyFile) != c.NotifyFile) {
+		return nil, fmt.Errorf("Invalid notify file '%s'. Must be a file name, without a directory", c.NotifyFile)
+	}
+
+	if c.NotifyInterval < 0 {
+		return nil, fmt.Errorf("Invalid notify interval %v. Cannot be negative", c.NotifyInterval)
+	}
+
+	notify.Terminals = c.NotifyTerminals
+	notify.File = c.NotifyFile
+	notify.Interval = c.NotifyInterval
+
+	if c.UsernameCacheTTL < 0 || c.UsernameNegativeCacheTTL < 0 {
+		return nil, fmt.Errorf("Invalid username cache TTL. Cannot be negative")
+	}
+
+	if c.UsernameLookupTimeout <= 0 {
+		return nil, fmt.Errorf("Invalid username lookup timeout %v. Must be positive", c.UsernameLookupTimeout)
+	}
+
+	hierarchy.UsernameCacheTTL = c.UsernameCacheTTL
+	hierarchy.UsernameNegativeCacheTTL = c.UsernameNegativeCacheTTL
+	hierarchy.UsernameLookupTimeout = c.UsernameLookupTimeout
+	hierarchy.SupplementaryGroups = c.SupplementaryGroups
 
 	return &c, err
 }
@@ -6,8 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"path"
+	"slices"
+	"strings"
 
+	"github.com/chpc-uofu/cgroup-warden/events"
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
 	//"github.com/containerd/cgroups/v3"
 	systemd "github.com/coreos/go-systemd/v22/dbus"
@@ -24,8 +29,43 @@ var (
 	MemorySwapMax      = "MemorySwapMax"
 	MemoryLow          = "MemoryLow"
 	MemoryMin          = "MemoryMin"
+	Frozen             = "Frozen"
+	Signal             = "Signal"
 )
 
+// backends through which a property is applied
+const (
+	BackendSystemd  = "systemd"
+	BackendCGroupFS = "cgroupfs"
+	BackendBoth     = "both"
+)
+
+// properties that can be written to cgroupfs directly, bypassing systemd.
+// Memory properties are clamped to the current usage of the unit this way.
+// Only the memory and swap limits are written to cgroupfs by default, as they
+// always have been.
+var (
+	cgroupMemoryProperties = []string{MemoryMax, MemorySwapMax, MemoryHigh, MemoryLow}
+	CGroupFSProperties     = append(slices.Clone(cgroupMemoryProperties), CPUQuotaPerSecUSec)
+	defaultCGroupFS        = []string{MemoryMax, MemorySwapMax}
+)
+
+// Backends selects the backend of properties in CGroupFSProperties. MemoryMax
+// and MemorySwapMax default to cgroupfs, and all others to systemd. With
+// both, the value applied to cgroupfs is also set through systemd, so that
+// it persists when systemd reapplies the properties of the unit.
+var Backends = map[string]string{}
+
+func backendFor(property string) string {
+	if backend, ok := Backends[property]; ok {
+		return backend
+	}
+	if slices.Contains(defaultCGroupFS, property) {
+		return BackendCGroupFS
+	}
+	return BackendSystemd
+}
+
 type controlProperty struct {
 	Name  string `json:"name"`
 	Value any    `json:"value"`
@@ -35,6 +75,14 @@ type controlRequest struct {
 	Unit     string          `json:"unit"`
 	Property controlProperty `json:"property"`
 	Runtime  bool            `json:"runtime"`
+
+	// Duration after which a frozen unit is thawed, like 30m
+	Duration string `json:"duration,omitempty"`
+
+	// Proc limits a signal to the processes with this name, and DryRun
+	// lists the processes that would be signalled without signalling them
+	Proc   string `json:"proc,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
 }
 
 type controlResponse struct {
@@ -42,9 +90,12 @@ type controlResponse struct {
 	Property controlProperty `json:"property"`
 	Error    string          `json:"error,omitempty"`
 	Warning  string          `json:"warning,omitempty"`
+	Targets  []signalTarget  `json:"targets,omitempty"`
 }
 
-func ControlHandler(cgroupRoot string) http.HandlerFunc {
+// ControlHandler applies a property to a unit, publishing an event to broker
+// once it has been applied.
+func ControlHandler(cgroupRoot string, broker *events.Broker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -73,13 +124,23 @@ func ControlHandler(cgroupRoot string) http.HandlerFunc {
 		response.Unit = request.Unit
 		response.Property = request.Property
 
+		err = validateUnit(request.Unit)
+		if err != nil {
+			slog.Warn("rejected request for invalid unit", "unit", request.Unit, "remote", r.RemoteAddr)
+			status = http.StatusBadRequest
+			return
+		}
+
 		slog.Debug("Decoded request", "unit", request.Unit, "property", request.Property.Name, "value", request.Property.Value)
 		
-		var newLimit int64
+		var newLimit, newSwap int64
 		var fallback bool = false
+		backend := backendFor(request.Property.Name)
 
-		if request.Property.Name == MemorySwapMax || request.Property.Name == MemoryMax {
-			newLimit, fallback, err = setCGroupMemoryLimits(request, cgroupRoot)
+		if request.DryRun && request.Property.Name != Signal {
+			err = errors.New("dry runs are only supported for signals")
+		} else if backend != BackendSystemd && slices.Contains(cgroupMemoryProperties, request.Property.Name) {
+			newLimit, newSwap, fallback, err = setCGroupMemoryLimits(request, cgroupRoot)
 
 			if newLimit == hierarchy.MaxCGroupMemoryLimit{
 				response.Property.Value = -1
@@ -87,9 +148,24 @@ func ControlHandler(cgroupRoot string) http.HandlerFunc {
 				response.Property.Value = newLimit
 			}
 
-			if fallback {
+			if fallback && request.Property.Name == MemoryLow {
+				response.Warning = fmt.Sprintf("memory low cannot exceed the memory limit, defaulted to current limit %d", newLimit)
+			} else if fallback {
 				response.Warning = fmt.Sprintf("unable to clamp memory limit down, defaulted to current usage %d", newLimit)
 			}
+
+			if err == nil && backend == BackendBoth {
+				err = setSystemdMemoryLimits(request, newLimit, newSwap)
+			}
+		} else if backend != BackendSystemd && request.Property.Name == CPUQuotaPerSecUSec {
+			err = setCGroupCPUQuota(request, cgroupRoot)
+			if err == nil && backend == BackendBoth {
+				err = setSystemdProperty(request)
+			}
+		} else if request.Property.Name == Signal {
+			response.Targets, err = signalProcesses(request, cgroupRoot, r.RemoteAddr)
+		} else if request.Property.Name == Frozen {
+			err = setFrozen(request, cgroupRoot, broker)
 		} else {
 			err = setSystemdProperty(request)
 		}
@@ -98,13 +174,57 @@ func ControlHandler(cgroupRoot string) http.HandlerFunc {
 			status = http.StatusBadRequest
 			return
 		}
+
+		data := map[string]any{
+			"property": response.Property.Name,
+			"value":    response.Property.Value,
+			"runtime":  request.Runtime,
+		}
+		if request.Duration != "" {
+			data["duration"] = request.Duration
+		}
+		if request.Property.Name == Signal {
+			if request.DryRun {
+				return
+			}
+			data["proc"] = request.Proc
+			data["targets"] = len(response.Targets)
+		}
+		publish(r.Context(), broker, cgroupRoot, request.Unit, data)
 	}
 }
 
-func setCGroupMemoryLimits(request controlRequest, cgroupRoot string) (int64, bool, error) {
+// validateUnit rejects units that are not a single path element, as units
+// are joined to the root cgroup, and one like ../system.slice would escape
+// it.
+func validateUnit(unit string) error {
+	if unit == "" || unit == "." || unit == ".." || strings.Contains(unit, "/") {
+		return fmt.Errorf("invalid unit '%s', expected a unit directly under the root cgroup", unit)
+	}
+	return nil
+}
+
+// publish publishes that the property in data was applied to unit.
+func publish(ctx context.Context, broker *events.Broker, cgroupRoot string, unit string, data map[string]any) {
+	e := events.Event{
+		Type:   events.ControlApplied,
+		CGroup: path.Join(cgroupRoot, unit),
+		Unit:   unit,
+		Data:   data,
+	}
+	if identity, err := hierarchy.LookupIdentity(ctx, unit); err == nil {
+		e.Username = identity.Username
+	}
+	broker.Publish(e)
+}
+
+// setCGroupMemoryLimits writes the memory property in request to cgroupfs,
+// returning the limit written and, for MemoryMax and MemorySwapMax, the swap
+// limit written alongside it, or -1 if swap was not limited on its own.
+func setCGroupMemoryLimits(request controlRequest, cgroupRoot string) (int64, int64, bool, error) {
 	val, ok := request.Property.Value.(float64)
 	if !ok {
-		return -1, false, errors.New("invalid type for property, expected float64")
+		return -1, -1, false, errors.New("invalid type for property, expected float64")
 	}
 
 	value := int64(val)
@@ -112,12 +232,71 @@ func setCGroupMemoryLimits(request controlRequest, cgroupRoot string) (int64, bo
 		value = hierarchy.MaxCGroupMemoryLimit
 	}
 
+	var newLimit int64
+	newSwap := int64(-1)
+	var err error
+
 	h := hierarchy.NewHierarchy(cgroupRoot)
-	newLimit, err := h.SetMemoryLimits(request.Unit, value)
+	switch request.Property.Name {
+	case MemoryHigh:
+		newLimit, err = h.SetMemoryHigh(request.Unit, value)
+	case MemoryLow:
+		newLimit, err = h.SetMemoryLow(request.Unit, value)
+	default:
+		newLimit, newSwap, err = h.SetMemoryLimits(request.Unit, value)
+	}
 
 	fallback := (newLimit != value && newLimit != -1)
 
-	return newLimit, fallback, err
+	return newLimit, newSwap, fallback, err
+}
+
+// setSystemdMemoryLimits sets the values written to cgroupfs for request
+// through systemd as well, so that they persist when systemd reapplies the
+// properties of the unit. MemoryMax and MemorySwapMax both write the memory
+// and swap limits to cgroupfs, so both are set.
+func setSystemdMemoryLimits(request controlRequest, limit int64, swap int64) error {
+	values := map[string]int64{request.Property.Name: limit}
+	if request.Property.Name == MemoryMax || request.Property.Name == MemorySwapMax {
+		values = map[string]int64{MemoryMax: limit}
+		if swap >= 0 {
+			values[MemorySwapMax] = swap
+		}
+	}
+
+	for name, value := range values {
+		applied := request
+		applied.Property = controlProperty{Name: name, Value: float64(value)}
+		if value >= hierarchy.MaxCGroupMemoryLimit {
+			applied.Property.Value = float64(-1)
+		}
+		err := setSystemdProperty(applied)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCGroupCPUQuota writes the CPU quota in request to cgroupfs. A negative
+// quota, or one too large to represent per period, removes the limit.
+func setCGroupCPUQuota(request controlRequest, cgroupRoot string) error {
+	val, ok := request.Property.Value.(float64)
+	if !ok {
+		return errors.New("invalid type for property, expected float64")
+	}
+
+	quota := int64(-1)
+	if val >= 0 && val < math.MaxInt64/hierarchy.CPUPeriod {
+		quota = int64(val)
+	}
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	err := h.SetCPUQuota(request.Unit, quota)
+	if err != nil {
+		slog.Warn("unable to set cpu quota", "err", err.Error(), "unit", request.Unit)
+	}
+	return err
 }
 
 func setSystemdProperty(request controlRequest) error {
@@ -159,7 +338,13 @@ func transform(controlProp controlProperty) (systemd.Property, error) {
 			return property, errors.New("invalid type for property, expected float64")
 		}
 
-		property.Value = dbus.MakeVariant(uint64(val))
+		// negative values are infinity, which systemd represents as the
+		// largest value
+		if val < 0 {
+			property.Value = dbus.MakeVariant(uint64(math.MaxUint64))
+		} else {
+			property.Value = dbus.MakeVariant(uint64(val))
+		}
 
 	default:
 		msg := fmt.Sprintf("property not supported: %v", controlProp.Name)
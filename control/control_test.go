@@ -0,0 +1,42 @@
+package control
+
+import "testing"
+
+func TestValidateUnit(t *testing.T) {
+	valid := []string{"user-1000.slice", "session-3.scope", "user@1000.service"}
+	for _, unit := range valid {
+		if err := validateUnit(unit); err != nil {
+			t.Errorf("validateUnit(%q) = %v, want nil", unit, err)
+		}
+	}
+
+	invalid := []string{"", ".", "..", "../system.slice", "/system.slice", "user-1000.slice/../../system.slice", "a/b"}
+	for _, unit := range invalid {
+		if err := validateUnit(unit); err == nil {
+			t.Errorf("validateUnit(%q) = nil, want an error", unit)
+		}
+	}
+}
+
+func TestBackendFor(t *testing.T) {
+	defer func(backends map[string]string) { Backends = backends }(Backends)
+
+	Backends = map[string]string{}
+	defaults := map[string]string{
+		MemoryMax:          BackendCGroupFS,
+		MemorySwapMax:      BackendCGroupFS,
+		MemoryHigh:         BackendSystemd,
+		MemoryLow:          BackendSystemd,
+		CPUQuotaPerSecUSec: BackendSystemd,
+	}
+	for property, want := range defaults {
+		if got := backendFor(property); got != want {
+			t.Errorf("backendFor(%s) = %s, want %s", property, got, want)
+		}
+	}
+
+	Backends = map[string]string{MemoryHigh: BackendBoth}
+	if got := backendFor(MemoryHigh); got != BackendBoth {
+		t.Errorf("backendFor(MemoryHigh) = %s with an override, want both", got)
+	}
+}
@@ -0,0 +1,261 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/events"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// FreezeStatePath is where the units frozen through the warden and their
+// automatic thaw deadlines are kept, so that a unit is still thawed on time
+// if the warden restarts. If empty, they are only kept in memory.
+var FreezeStatePath string
+
+// FreezeSweepInterval is how often processes that joined a frozen unit are
+// moved into its freezer cgroup on the legacy hierarchy, where a frozen unit
+// does not freeze processes placed in it afterwards.
+var FreezeSweepInterval = 10 * time.Second
+
+// An automatic thaw that fails is retried after ThawBackoff, doubling up to
+// MaxThawBackoff, until the unit is thawed or no longer exists.
+var (
+	ThawBackoff    = 10 * time.Second
+	MaxThawBackoff = 5 * time.Minute
+)
+
+// units frozen through the warden, by unit, with their thaw deadline or the
+// zero time if they stay frozen, and the timers of pending automatic thaws
+var thaws = struct {
+	timers    map[string]*time.Timer
+	deadlines map[string]time.Time
+	mutex     sync.Mutex
+}{timers: make(map[string]*time.Timer), deadlines: make(map[string]time.Time)}
+
+// setFrozen freezes or thaws the unit in request. A unit frozen with a
+// duration is thawed once it passes, unless it is frozen or thawed again
+// before then.
+func setFrozen(request controlRequest, cgroupRoot string, broker *events.Broker) error {
+	frozen, ok := request.Property.Value.(bool)
+	if !ok {
+		return errors.New("invalid type for property, expected bool")
+	}
+
+	var duration time.Duration
+	if request.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration '%s', expected a positive duration like 30m", request.Duration)
+		}
+		if !frozen {
+			return errors.New("a duration can only be given when freezing")
+		}
+	}
+
+	defer thaws.mutex.Unlock()
+	thaws.mutex.Lock()
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	err := h.SetFrozen(request.Unit, frozen)
+	if err != nil {
+		slog.Warn("unable to set frozen state", "unit", request.Unit, "frozen", frozen, "err", err)
+		return err
+	}
+
+	if timer, ok := thaws.timers[request.Unit]; ok {
+		timer.Stop()
+		delete(thaws.timers, request.Unit)
+	}
+
+	if !frozen {
+		delete(thaws.deadlines, request.Unit)
+		saveFrozen()
+		return nil
+	}
+
+	var deadline time.Time
+	if duration > 0 {
+		deadline = time.Now().Add(duration)
+		armThaw(request.Unit, cgroupRoot, broker, duration, ThawBackoff)
+		if FreezeStatePath == "" {
+			slog.Warn("automatic thaw is only kept in memory, the unit stays frozen if the warden restarts first", "unit", request.Unit, "duration", duration)
+		}
+	}
+	thaws.deadlines[request.Unit] = deadline
+	saveFrozen()
+	return nil
+}
+
+// armThaw thaws unit after duration, retrying after backoff if that fails.
+// It must be called with the lock held, so the timer cannot fire before it
+// is stored, as thaw waits on the lock.
+func armThaw(unit string, cgroupRoot string, broker *events.Broker, duration time.Duration, backoff time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		thaw(unit, cgroupRoot, broker, timer, backoff)
+	})
+	thaws.timers[unit] = timer
+}
+
+// thaw thaws unit once the duration it was frozen for has passed, unless
+// timer was replaced by a later request. If the unit cannot be thawed, the
+// thaw is retried after backoff, and the unit is forgotten if it no longer
+// exists.
+func thaw(unit string, cgroupRoot string, broker *events.Broker, timer *time.Timer, backoff time.Duration) {
+	defer thaws.mutex.Unlock()
+	thaws.mutex.Lock()
+
+	if thaws.timers[unit] != timer {
+		return
+	}
+	delete(thaws.timers, unit)
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	err := h.SetFrozen(unit, false)
+	if err != nil {
+		if _, pidsErr := h.GetPIDs(unit); pidsErr != nil {
+			slog.Info("forgetting frozen unit that can no longer be read", "unit", unit, "err", pidsErr)
+			delete(thaws.deadlines, unit)
+			saveFrozen()
+			return
+		}
+
+		slog.Error("unable to thaw unit, retrying", "unit", unit, "retry", backoff, "err", err)
+		armThaw(unit, cgroupRoot, broker, backoff, min(2*backoff, MaxThawBackoff))
+		return
+	}
+
+	delete(thaws.deadlines, unit)
+	saveFrozen()
+
+	slog.Info("thawed unit after freeze duration", "unit", unit)
+	publish(context.Background(), broker, cgroupRoot, unit, map[string]any{
+		"property":  Frozen,
+		"value":     false,
+		"automatic": true,
+	})
+}
+
+// saveFrozen atomically writes the frozen units to FreezeStatePath. It must
+// be called with the lock held.
+func saveFrozen() {
+	if FreezeStatePath == "" {
+		return
+	}
+
+	buf, err := json.Marshal(thaws.deadlines)
+	if err == nil {
+		tmp := FreezeStatePath + ".tmp"
+		err = os.WriteFile(tmp, buf, 0600)
+		if err == nil {
+			err = os.Rename(tmp, FreezeStatePath)
+		}
+	}
+	if err != nil {
+		slog.Error("unable to save frozen units, automatic thaws will be lost on restart", "path", FreezeStatePath, "err", err)
+	}
+}
+
+// ResumeFrozen restores the units frozen before the warden last exited from
+// FreezeStatePath, re-arming their automatic thaws. Units whose deadline
+// passed while the warden was not running are thawed at once.
+func ResumeFrozen(cgroupRoot string, broker *events.Broker) error {
+	if FreezeStatePath == "" {
+		return nil
+	}
+
+	buf, err := os.ReadFile(FreezeStatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	deadlines := make(map[string]time.Time)
+	err = json.Unmarshal(buf, &deadlines)
+	if err != nil {
+		return fmt.Errorf("unable to parse frozen units '%s': %w", FreezeStatePath, err)
+	}
+
+	defer thaws.mutex.Unlock()
+	thaws.mutex.Lock()
+
+	for unit, deadline := range deadlines {
+		if validateUnit(unit) != nil {
+			continue
+		}
+		thaws.deadlines[unit] = deadline
+		if !deadline.IsZero() {
+			slog.Info("resuming automatic thaw", "unit", unit, "deadline", deadline)
+			armThaw(unit, cgroupRoot, broker, max(time.Until(deadline), 0), ThawBackoff)
+		}
+	}
+	return nil
+}
+
+// joiner is implemented by the hierarchies where processes placed in a
+// frozen unit are not frozen until they are moved into its freezer cgroup.
+type joiner interface {
+	JoinFrozen(unit string) (bool, error)
+}
+
+// SweepFrozen moves processes that joined a unit frozen through the warden
+// into its freezer cgroup every FreezeSweepInterval until ctx is cancelled.
+// Only the legacy and hybrid hierarchies need this, as a frozen cgroup on
+// the unified hierarchy freezes every process placed in it.
+func SweepFrozen(ctx context.Context, cgroupRoot string) {
+	if _, ok := hierarchy.NewHierarchy(cgroupRoot).(joiner); !ok {
+		return
+	}
+
+	ticker := time.NewTicker(FreezeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepFrozen(cgroupRoot)
+		}
+	}
+}
+
+// sweepFrozen moves the processes that joined the units frozen through the
+// warden into their freezer cgroups. Units that were thawed by other means
+// or no longer exist are forgotten, along with their automatic thaw.
+func sweepFrozen(cgroupRoot string) {
+	defer thaws.mutex.Unlock()
+	thaws.mutex.Lock()
+
+	h, ok := hierarchy.NewHierarchy(cgroupRoot).(joiner)
+	if !ok {
+		return
+	}
+
+	for unit := range thaws.deadlines {
+		frozen, err := h.JoinFrozen(unit)
+		if err != nil {
+			slog.Info("forgetting frozen unit that can no longer be read", "unit", unit, "err", err)
+		} else if !frozen {
+			slog.Info("forgetting frozen unit that was thawed by other means", "unit", unit)
+		} else {
+			continue
+		}
+
+		if timer, ok := thaws.timers[unit]; ok {
+			timer.Stop()
+			delete(thaws.timers, unit)
+		}
+		delete(thaws.deadlines, unit)
+		saveFrozen()
+	}
+}
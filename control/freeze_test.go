@@ -0,0 +1,174 @@
+package control
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+const (
+	fixtureRoot = "/user.slice"
+	fixtureUnit = "user-1000.slice"
+)
+
+// freezeFixture points the hierarchy at a legacy cgroup tree holding a unit
+// frozen through the warden, and FreezeStatePath at a file next to it.
+func freezeFixture(t *testing.T) string {
+	t.Helper()
+
+	mount := t.TempDir()
+	saved, savedState := hierarchy.MountPoint, FreezeStatePath
+	hierarchy.MountPoint = mount
+	FreezeStatePath = path.Join(t.TempDir(), "frozen.json")
+	t.Cleanup(func() {
+		hierarchy.MountPoint, FreezeStatePath = saved, savedState
+		thaws.mutex.Lock()
+		for unit, timer := range thaws.timers {
+			timer.Stop()
+			delete(thaws.timers, unit)
+		}
+		clear(thaws.deadlines)
+		thaws.mutex.Unlock()
+	})
+
+	cg := path.Join(fixtureRoot, fixtureUnit)
+	files := map[string]string{
+		path.Join("cpuacct", cg, "cgroup.procs"):  "100\n",
+		path.Join("freezer", cg, "cgroup.procs"):  "100\n",
+		path.Join("freezer", cg, "freezer.state"): "FROZEN\n",
+	}
+	for file, content := range files {
+		file = path.Join(mount, file)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	thaws.mutex.Lock()
+	thaws.deadlines[fixtureUnit] = time.Now().Add(time.Hour)
+	saveFrozen()
+	thaws.mutex.Unlock()
+
+	return mount
+}
+
+// savedUnits returns the units in FreezeStatePath.
+func savedUnits(t *testing.T) map[string]time.Time {
+	t.Helper()
+	buf, err := os.ReadFile(FreezeStatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make(map[string]time.Time)
+	if err := json.Unmarshal(buf, &units); err != nil {
+		t.Fatal(err)
+	}
+	return units
+}
+
+func TestThawRetried(t *testing.T) {
+	mount := freezeFixture(t)
+	defer func(backoff time.Duration) { ThawBackoff = backoff }(ThawBackoff)
+	ThawBackoff = time.Hour
+
+	// freezer.state cannot be written, so the thaw fails
+	state := path.Join(mount, "freezer", fixtureRoot, fixtureUnit, "freezer.state")
+	if err := os.Remove(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(state, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	thaws.mutex.Lock()
+	armThaw(fixtureUnit, fixtureRoot, nil, time.Hour, ThawBackoff)
+	timer := thaws.timers[fixtureUnit]
+	thaws.mutex.Unlock()
+	timer.Stop()
+
+	thaw(fixtureUnit, fixtureRoot, nil, timer, ThawBackoff)
+
+	thaws.mutex.Lock()
+	retry, ok := thaws.timers[fixtureUnit]
+	_, frozen := thaws.deadlines[fixtureUnit]
+	thaws.mutex.Unlock()
+
+	if !ok || retry == timer {
+		t.Errorf("failed thaw was not re-armed")
+	}
+	if _, saved := savedUnits(t)[fixtureUnit]; !frozen || !saved {
+		t.Errorf("unit that could not be thawed was forgotten")
+	}
+}
+
+func TestThawForgetsRemovedUnit(t *testing.T) {
+	mount := freezeFixture(t)
+
+	if err := os.RemoveAll(path.Join(mount, "cpuacct", fixtureRoot, fixtureUnit)); err != nil {
+		t.Fatal(err)
+	}
+	state := path.Join(mount, "freezer", fixtureRoot, fixtureUnit, "freezer.state")
+	if err := os.Remove(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(state, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	thaws.mutex.Lock()
+	timer := time.NewTimer(time.Hour)
+	thaws.timers[fixtureUnit] = timer
+	thaws.mutex.Unlock()
+	timer.Stop()
+
+	thaw(fixtureUnit, fixtureRoot, nil, timer, ThawBackoff)
+
+	thaws.mutex.Lock()
+	_, armed := thaws.timers[fixtureUnit]
+	thaws.mutex.Unlock()
+	if armed {
+		t.Errorf("thaw of a removed unit was re-armed")
+	}
+	if _, saved := savedUnits(t)[fixtureUnit]; saved {
+		t.Errorf("removed unit was kept in %s", FreezeStatePath)
+	}
+}
+
+func TestSweepFrozen(t *testing.T) {
+	mount := freezeFixture(t)
+	cg := path.Join(fixtureRoot, fixtureUnit)
+
+	// a new process joins the frozen unit, and is moved into the freezer
+	if err := os.WriteFile(path.Join(mount, "cpuacct", cg, "cgroup.procs"), []byte("100\n101\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(mount, "freezer", cg, "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sweepFrozen(fixtureRoot)
+	if buf, _ := os.ReadFile(path.Join(mount, "freezer", cg, "cgroup.procs")); len(buf) == 0 {
+		t.Errorf("processes of a frozen unit were not moved into the freezer")
+	}
+
+	// the unit is thawed by other means, so it is no longer swept
+	if err := os.WriteFile(path.Join(mount, "freezer", cg, "freezer.state"), []byte("THAWED\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(mount, "freezer", cg, "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sweepFrozen(fixtureRoot)
+	if buf, _ := os.ReadFile(path.Join(mount, "freezer", cg, "cgroup.procs")); len(buf) != 0 {
+		t.Errorf("processes were moved into a thawed freezer cgroup: %q", buf)
+	}
+	if _, saved := savedUnits(t)[fixtureUnit]; saved {
+		t.Errorf("unit thawed by other means was kept in %s", FreezeStatePath)
+	}
+}
@@ -0,0 +1,162 @@
+package control
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+type signalTarget struct {
+	PID   uint64 `json:"pid"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// signalProcesses sends the signal in request to the processes of its unit,
+// or only those named request.Proc, as named in the proc label of the
+// metrics. Nothing is sent on a dry run, but the processes that would be
+// signalled are returned all the same. Every signal sent is audit logged
+// along with remote, the address of the requester.
+func signalProcesses(request controlRequest, cgroupRoot string, remote string) ([]signalTarget, error) {
+	name, ok := request.Property.Value.(string)
+	if !ok {
+		return nil, errors.New("invalid type for property, expected string")
+	}
+
+	sig := parseSignal(name)
+	if sig == 0 {
+		return nil, fmt.Errorf("unknown signal '%s'", name)
+	}
+	name = unix.SignalName(sig)
+
+	audit := slog.With("audit", true, "remote", remote, "unit", request.Unit, "signal", name, "proc", request.Proc, "dry_run", request.DryRun)
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+
+	pids, err := h.GetPIDs(request.Unit)
+	if err != nil {
+		audit.Warn("unable to list processes of unit", "err", err)
+		return nil, err
+	}
+
+	// the whole unit can be killed at once, without racing new processes
+	if sig == unix.SIGKILL && request.Proc == "" && !request.DryRun {
+		err := h.Kill(request.Unit)
+		if err == nil {
+			audit.Info("killed unit with cgroup.kill", "pids", slices.Sorted(maps.Keys(pids)))
+			return nil, nil
+		}
+		if !errors.Is(err, hierarchy.ErrKillUnsupported) {
+			audit.Warn("unable to kill unit with cgroup.kill", "err", err)
+			return nil, err
+		}
+	}
+
+	fs, err := procfs.NewFS(hierarchy.ProcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	unitPath := path.Join(cgroupRoot, request.Unit)
+	targets := make([]signalTarget, 0, len(pids))
+	for pid := range pids {
+		target, ok := signalProcess(fs, pid, sig, unitPath, request)
+		if !ok {
+			continue
+		}
+		if target.Error != "" {
+			audit.Warn("unable to signal process", "pid", target.PID, "name", target.Name, "err", target.Error)
+		} else if !request.DryRun {
+			audit.Info("signalled process", "pid", target.PID, "name", target.Name)
+		}
+		targets = append(targets, target)
+	}
+
+	slices.SortFunc(targets, func(a, b signalTarget) int {
+		return cmp.Compare(a.PID, b.PID)
+	})
+	audit.Info("processed signal request", "targets", len(targets))
+	return targets, nil
+}
+
+// signalProcess sends sig to pid if it is still in unitPath and named as
+// requested, returning false if it is not a target. The process is pinned
+// with a pidfd before it is checked, so that the signal cannot reach a
+// process that reused its pid.
+func signalProcess(fs procfs.FS, pid uint64, sig syscall.Signal, unitPath string, request controlRequest) (signalTarget, bool) {
+	target := signalTarget{PID: pid}
+
+	// pidfds require Linux 5.3, before which the pid is signalled directly
+	pidfd := -1
+	fd, err := unix.PidfdOpen(int(pid), 0)
+	switch {
+	case err == nil:
+		pidfd = fd
+		defer unix.Close(pidfd)
+	case !errors.Is(err, unix.ENOSYS):
+		return target, false
+	}
+
+	proc, err := fs.Proc(int(pid))
+	if err != nil {
+		return target, false
+	}
+
+	target.Name, err = metrics.ProcessName(proc)
+	if err != nil {
+		return target, false
+	}
+	if request.Proc != "" && target.Name != request.Proc {
+		return target, false
+	}
+
+	cgroups, err := proc.Cgroups()
+	if err != nil || !slices.ContainsFunc(cgroups, func(cg procfs.Cgroup) bool {
+		return cg.Path == unitPath || strings.HasPrefix(cg.Path, unitPath+"/")
+	}) {
+		return target, false
+	}
+
+	if request.DryRun {
+		return target, true
+	}
+
+	if pidfd >= 0 {
+		err = unix.PidfdSendSignal(pidfd, sig, nil, 0)
+	} else {
+		err = unix.Kill(int(pid), sig)
+	}
+	if err != nil {
+		target.Error = err.Error()
+	}
+	return target, true
+}
+
+// parseSignal parses a signal name like SIGTERM or TERM, or number, returning
+// zero if it is not a signal.
+func parseSignal(name string) syscall.Signal {
+	if n, err := strconv.Atoi(name); err == nil {
+		if unix.SignalName(syscall.Signal(n)) == "" {
+			return 0
+		}
+		return syscall.Signal(n)
+	}
+
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return unix.SignalNum(name)
+}
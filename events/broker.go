@@ -0,0 +1,95 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// types of events published
+const (
+	CGroupCreated     = "cgroup.created"
+	CGroupRemoved     = "cgroup.removed"
+	ControlApplied    = "control.applied"
+	ThresholdCrossed  = "threshold.crossed"
+	ThresholdCleared  = "threshold.cleared"
+	memoryEventPrefix = "memory."
+)
+
+// memory events are named after their key in memory.events
+const (
+	MemoryLow     = memoryEventPrefix + "low"
+	MemoryHigh    = memoryEventPrefix + "high"
+	MemoryMax     = memoryEventPrefix + "max"
+	MemoryOOM     = memoryEventPrefix + "oom"
+	MemoryOOMKill = memoryEventPrefix + "oom_kill"
+)
+
+// number of events buffered per subscriber before events are dropped
+const subscriberBuffer = 64
+
+// Event is something that happened to a cgroup under the root.
+type Event struct {
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	CGroup   string         `json:"cgroup,omitempty"`
+	Unit     string         `json:"unit,omitempty"`
+	Username string         `json:"username,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Broker fans out published events to every subscriber. A nil Broker
+// discards events.
+type Broker struct {
+	subscribers map[chan Event]bool
+	mutex       sync.Mutex
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan Event]bool),
+		mutex:       sync.Mutex{},
+	}
+}
+
+// Publish sends e to every subscriber. Subscribers that are not keeping up
+// miss the event rather than block the publisher.
+func (b *Broker) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	defer b.mutex.Unlock()
+	b.mutex.Lock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			slog.Warn("subscriber is not keeping up, dropping event", "type", e.Type, "cgroup", e.CGroup)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a function that ends the subscription.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mutex.Lock()
+	b.subscribers[ch] = true
+	b.mutex.Unlock()
+
+	cancel := func() {
+		defer b.mutex.Unlock()
+		b.mutex.Lock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
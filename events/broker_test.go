@@ -0,0 +1,80 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBrokerFanOut(t *testing.T) {
+	b := NewBroker()
+	first, cancelFirst := b.Subscribe()
+	second, cancelSecond := b.Subscribe()
+	defer cancelSecond()
+
+	b.Publish(Event{Type: CGroupCreated, CGroup: "/user.slice/user-1000.slice"})
+	for i, ch := range []<-chan Event{first, second} {
+		select {
+		case e := <-ch:
+			if e.Type != CGroupCreated || e.Time.IsZero() {
+				t.Errorf("subscriber %d got %+v, want a timestamped %s", i, e, CGroupCreated)
+			}
+		default:
+			t.Errorf("subscriber %d got no event", i)
+		}
+	}
+
+	// a cancelled subscription is closed and receives nothing more
+	cancelFirst()
+	cancelFirst()
+	b.Publish(Event{Type: CGroupRemoved})
+	if e, ok := <-first; ok {
+		t.Errorf("cancelled subscriber got %+v", e)
+	}
+	if e := <-second; e.Type != CGroupRemoved {
+		t.Errorf("got %s, want %s", e.Type, CGroupRemoved)
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	_, cancel := b.Subscribe()
+	defer cancel()
+
+	// publishing to a subscriber that never reads must not block
+	done := make(chan struct{})
+	go func() {
+		for range subscriberBuffer + 1 {
+			b.Publish(Event{Type: MemoryHigh})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+}
+
+func TestNilBroker(t *testing.T) {
+	var b *Broker
+	b.Publish(Event{Type: CGroupCreated})
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		types []string
+		t     string
+		want  bool
+	}{
+		{nil, MemoryOOM, true},
+		{[]string{"memory."}, MemoryOOM, true},
+		{[]string{"memory."}, ThresholdCrossed, false},
+		{[]string{CGroupCreated, " " + ThresholdCrossed}, ThresholdCrossed, true},
+		{[]string{MemoryHigh}, MemoryMax, false},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.types, tt.t); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tt.types, tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// interval at which comments are sent to keep idle connections open
+const keepAlive = 30 * time.Second
+
+// StreamHandler streams events published to b as server-sent events. The
+// optional type query parameter is a comma separated list of event types to
+// receive, where a type ending in a dot matches every type with that prefix,
+// like memory. for all memory events.
+func StreamHandler(b *Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		var types []string
+		if value := r.URL.Query().Get("type"); value != "" {
+			types = strings.Split(value, ",")
+		}
+
+		events, cancel := b.Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if !Matches(types, e.Type) {
+					continue
+				}
+				buf, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, buf)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Matches reports whether the event type t is one of types, where a type
+// ending in a dot matches every type with that prefix. Every type matches an
+// empty list.
+func Matches(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(types, func(pattern string) bool {
+		pattern = strings.TrimSpace(pattern)
+		if strings.HasSuffix(pattern, ".") {
+			return strings.HasPrefix(t, pattern)
+		}
+		return t == pattern
+	})
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+)
+
+// resources with usage thresholds
+const (
+	ResourceMemory = "memory"
+	ResourceCPU    = "cpu"
+)
+
+// Thresholds publishes an event when the usage of a cgroup crosses a
+// fraction of its limit, and when it falls back below. Memory usage is
+// compared against the memory limit, and CPU usage in cores since the
+// previous collection against the CPU quota. A threshold of zero is
+// disabled, as are limits that are unset.
+type Thresholds struct {
+	broker   *Broker
+	memory   float64
+	cpu      float64
+	previous map[string]sample
+	crossed  map[string]map[string]bool
+	mutex    sync.Mutex
+}
+
+type sample struct {
+	time time.Time
+	cpu  float64
+}
+
+func NewThresholds(b *Broker, memory, cpu float64) *Thresholds {
+	return &Thresholds{
+		broker:   b,
+		memory:   memory,
+		cpu:      cpu,
+		previous: make(map[string]sample),
+		crossed:  make(map[string]map[string]bool),
+		mutex:    sync.Mutex{},
+	}
+}
+
+func (t *Thresholds) Observe(usage []metrics.GroupUsage) {
+	defer t.mutex.Unlock()
+	t.mutex.Lock()
+
+	seen := make(map[string]bool, len(usage))
+	for _, u := range usage {
+		seen[u.CGroup] = true
+		info := u.Info
+
+		if t.memory > 0 && info.MemoryMax > 0 && info.MemoryMax < hierarchy.MaxCGroupMemoryLimit {
+			t.check(u, ResourceMemory, float64(info.MemoryUsage), float64(info.MemoryMax), t.memory)
+		}
+
+		prev, ok := t.previous[u.CGroup]
+		t.previous[u.CGroup] = sample{time: u.Time, cpu: info.CPUUsage}
+		seconds := u.Time.Sub(prev.time).Seconds()
+		if t.cpu > 0 && ok && seconds > 0 && info.CPUQuota > 0 && info.CPUUsage >= prev.cpu {
+			cores := (info.CPUUsage - prev.cpu) / seconds
+			t.check(u, ResourceCPU, cores, float64(info.CPUQuota)/hierarchy.USPerS, t.cpu)
+		}
+	}
+
+	for cg := range t.previous {
+		if !seen[cg] {
+			delete(t.previous, cg)
+			delete(t.crossed, cg)
+		}
+	}
+}
+
+func (t *Thresholds) check(u metrics.GroupUsage, resource string, value, limit, threshold float64) {
+	crossed, ok := t.crossed[u.CGroup]
+	if !ok {
+		crossed = make(map[string]bool)
+		t.crossed[u.CGroup] = crossed
+	}
+
+	above := value >= limit*threshold
+	if above == crossed[resource] {
+		return
+	}
+	crossed[resource] = above
+
+	e := Event{
+		Type:     ThresholdCleared,
+		Time:     u.Time,
+		CGroup:   u.CGroup,
+		Unit:     path.Base(u.CGroup),
+		Username: u.Info.Username,
+		Data: map[string]any{
+			"resource":  resource,
+			"value":     value,
+			"limit":     limit,
+			"threshold": threshold,
+		},
+	}
+	if above {
+		e.Type = ThresholdCrossed
+	}
+	t.broker.Publish(e)
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+)
+
+const testCGroup = "/user.slice/user-1000.slice"
+
+// received returns the events waiting in ch.
+func received(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func expect(t *testing.T, ch <-chan Event, step string, want ...string) []Event {
+	t.Helper()
+	events := received(ch)
+	if len(events) != len(want) {
+		t.Fatalf("%s: got %d events, want %v", step, len(events), want)
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("%s: got %s, want %s", step, e.Type, want[i])
+		}
+	}
+	return events
+}
+
+func TestThresholdsMemory(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe()
+	defer cancel()
+	th := NewThresholds(b, 0.9, 0)
+
+	observe := func(usage uint64) {
+		th.Observe([]metrics.GroupUsage{{
+			CGroup: testCGroup,
+			Time:   time.Now(),
+			Info:   hierarchy.CGroupInfo{MemoryUsage: usage, MemoryMax: 1000, Identity: hierarchy.Identity{Username: "u0"}},
+		}})
+	}
+
+	observe(500)
+	expect(t, ch, "below")
+
+	observe(950)
+	events := expect(t, ch, "crossing", ThresholdCrossed)
+	e := events[0]
+	if e.Unit != "user-1000.slice" || e.Username != "u0" || e.Data["resource"] != ResourceMemory {
+		t.Errorf("got %+v, want a memory event for user-1000.slice", e)
+	}
+
+	// staying above publishes nothing more
+	observe(990)
+	expect(t, ch, "above")
+
+	observe(100)
+	expect(t, ch, "clearing", ThresholdCleared)
+}
+
+func TestThresholdsCPU(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe()
+	defer cancel()
+	th := NewThresholds(b, 0, 0.8)
+
+	now := time.Now()
+	observe := func(seconds int, usage float64) {
+		th.Observe([]metrics.GroupUsage{{
+			CGroup: testCGroup,
+			Time:   now.Add(time.Duration(seconds) * time.Second),
+			// a quota of two cores
+			Info: hierarchy.CGroupInfo{CPUUsage: usage, CPUQuota: 2 * hierarchy.USPerS},
+		}})
+	}
+
+	// the first collection has nothing to compare against
+	observe(0, 0)
+	expect(t, ch, "first")
+
+	// 1.9 cores over ten seconds
+	observe(10, 19)
+	events := expect(t, ch, "crossing", ThresholdCrossed)
+	if got := events[0].Data["value"]; got != 1.9 {
+		t.Errorf("got %v cores, want 1.9", got)
+	}
+
+	// idle
+	observe(20, 19)
+	expect(t, ch, "clearing", ThresholdCleared)
+}
+
+func TestThresholdsForgetRemoved(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe()
+	defer cancel()
+	th := NewThresholds(b, 0.5, 0)
+
+	usage := []metrics.GroupUsage{{
+		CGroup: testCGroup,
+		Time:   time.Now(),
+		Info:   hierarchy.CGroupInfo{MemoryUsage: 900, MemoryMax: 1000},
+	}}
+	th.Observe(usage)
+	expect(t, ch, "crossing", ThresholdCrossed)
+
+	// the cgroup goes away and comes back still above the threshold, which
+	// is a new crossing rather than a continuation
+	th.Observe(nil)
+	th.Observe(usage)
+	expect(t, ch, "returning", ThresholdCrossed)
+}
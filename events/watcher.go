@@ -0,0 +1,219 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+const (
+	rootMask   = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_ONLYDIR
+	eventsMask = syscall.IN_MODIFY
+)
+
+// Watcher publishes the creation and removal of cgroups directly under the
+// root, and increments of the memory.events counters of those cgroups, as
+// they happen. It requires the unified hierarchy, which notifies inotify
+// watchers when memory.events changes.
+type Watcher struct {
+	// the raw fd is kept for adding watches, as calling Fd on file would
+	// put it into blocking mode and keep Close from interrupting reads
+	broker  *Broker
+	root    string
+	dir     string
+	fd      int
+	file    *os.File
+	watches map[int32]string
+	groups  map[string]int32
+	counts  map[string]map[string]uint64
+}
+
+// NewWatcher watches the cgroups under root, publishing events to b.
+func NewWatcher(b *Broker, root string) (*Watcher, error) {
+	dir, ok := hierarchy.UnifiedPath(root)
+	if !ok {
+		return nil, errors.New("watching cgroup events requires the unified cgroup hierarchy")
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize inotify: %w", err)
+	}
+
+	w := &Watcher{
+		broker:  b,
+		root:    root,
+		dir:     dir,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]string),
+		groups:  make(map[string]int32),
+		counts:  make(map[string]map[string]uint64),
+	}
+
+	wd, err := syscall.InotifyAddWatch(fd, dir, rootMask)
+	if err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("unable to watch '%s': %w", dir, err)
+	}
+	w.watches[int32(wd)] = root
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			w.watch(path.Join(root, entry.Name()))
+		}
+	}
+
+	return w, nil
+}
+
+// watch starts watching the memory.events of cg, recording the current
+// counters as the baseline.
+func (w *Watcher) watch(cg string) {
+	file := path.Join(w.dir, path.Base(cg), "memory.events")
+	wd, err := syscall.InotifyAddWatch(w.fd, file, eventsMask)
+	if err != nil {
+		slog.Debug("unable to watch memory events", "cgroup", cg, "err", err)
+		return
+	}
+
+	w.watches[int32(wd)] = cg
+	w.groups[cg] = int32(wd)
+	w.counts[cg], _ = readMemoryEvents(file)
+}
+
+func (w *Watcher) forget(cg string) {
+	if wd, ok := w.groups[cg]; ok {
+		delete(w.watches, wd)
+	}
+	delete(w.groups, cg)
+	delete(w.counts, cg)
+}
+
+// Run publishes events until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("unable to read inotify events, no longer watching cgroups", "err", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			length := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[start:start+length], "\x00"))
+			offset = start + length
+
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	cg, ok := w.watches[wd]
+	if !ok {
+		return
+	}
+
+	switch {
+	case cg == w.root && mask&syscall.IN_ISDIR == 0:
+		return
+	case cg == w.root && mask&syscall.IN_CREATE != 0:
+		child := path.Join(w.root, name)
+		w.watch(child)
+		w.publish(Event{Type: CGroupCreated, CGroup: child})
+	case cg == w.root && mask&syscall.IN_DELETE != 0:
+		child := path.Join(w.root, name)
+		w.forget(child)
+		w.publish(Event{Type: CGroupRemoved, CGroup: child})
+	case mask&syscall.IN_MODIFY != 0:
+		w.modified(cg)
+	}
+}
+
+// modified publishes an event for every memory.events counter of cg that
+// increased since it was last read.
+func (w *Watcher) modified(cg string) {
+	counts, err := readMemoryEvents(path.Join(w.dir, path.Base(cg), "memory.events"))
+	if err != nil {
+		slog.Debug("unable to read memory events", "cgroup", cg, "err", err)
+		return
+	}
+
+	previous := w.counts[cg]
+	w.counts[cg] = counts
+	for key, count := range counts {
+		if count <= previous[key] {
+			continue
+		}
+		w.publish(Event{
+			Type:   memoryEventPrefix + key,
+			CGroup: cg,
+			Data: map[string]any{
+				"count": count,
+				"delta": count - previous[key],
+			},
+		})
+	}
+}
+
+// publish sends e to the broker. The username is taken from the identity
+// cache, as waiting on the lookup service would hold up the inotify loop.
+func (w *Watcher) publish(e Event) {
+	if identity, err := hierarchy.CachedIdentity(e.CGroup); err == nil {
+		e.Username = identity.Username
+	}
+	e.Unit = path.Base(e.CGroup)
+	w.broker.Publish(e)
+}
+
+func readMemoryEvents(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counts := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %w", file, err)
+		}
+		counts[key] = count
+	}
+	return counts, scanner.Err()
+}
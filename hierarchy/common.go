@@ -1,67 +1,304 @@
 package hierarchy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os/user"
-	"regexp"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/containerd/cgroups/v3"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	USPerS               = 1000000    // million
 	NSPerS               = 1000000000 // billion
 	MaxCGroupMemoryLimit = 9223372036854771712
-	LimitBuffer 		 = 4096 * 100
-	cgroupRoot           = "/sys/fs/cgroup"
+	LimitBuffer          = 4096 * 100
+	CPUPeriod            = 100000 // default CFS period in micro seconds
 )
 
+// MountPoint is where cgroupfs is mounted, and ProcPath where procfs is
+// mounted. They differ from the defaults in containers with the host
+// filesystems bind mounted elsewhere, or when reading a fixture tree.
+var (
+	MountPoint = "/sys/fs/cgroup"
+	ProcPath   = "/proc"
+)
+
+// ForceMode is the cgroup mode used instead of the one detected at
+// MountPoint, unless it is cgroups.Unavailable. It lets a fixture tree, which
+// is not cgroupfs, stand in for any mode.
+var ForceMode = cgroups.Unavailable
+
+// hybridUnifiedRoot is where the unified hierarchy is mounted in hybrid mode.
+func hybridUnifiedRoot() string {
+	return path.Join(MountPoint, "unified")
+}
+
+// Mode returns ForceMode if set, or else the cgroup mode of the hierarchy at
+// MountPoint. Unlike cgroups.Mode, it does not assume the hierarchy is at
+// /sys/fs/cgroup.
+func Mode() cgroups.CGMode {
+	if ForceMode != cgroups.Unavailable {
+		return ForceMode
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(MountPoint, &st); err != nil {
+		return cgroups.Unavailable
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return cgroups.Unified
+	}
+	if err := unix.Statfs(hybridUnifiedRoot(), &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return cgroups.Hybrid
+	}
+	return cgroups.Legacy
+}
+
 type Hierarchy interface {
-	GetGroupsWithPIDs() (map[string]map[uint64]bool, error)
-	CGroupInfo(cg string) (CGroupInfo, error)
-	SetMemoryLimits(unit string, limit int64) (int64, error)
+	GetGroupsWithPIDs(ctx context.Context) (map[string]map[uint64]bool, error)
+	CGroupInfo(ctx context.Context, cg string) (CGroupInfo, error)
+	SetMemoryLimits(unit string, limit int64) (int64, int64, error)
+	SetMemoryHigh(unit string, limit int64) (int64, error)
+	SetMemoryLow(unit string, limit int64) (int64, error)
+	SetCPUQuota(unit string, quota int64) error
+	SetFrozen(unit string, frozen bool) error
+	GetPIDs(unit string) (map[uint64]bool, error)
+	Kill(unit string) error
 }
 
+// ErrKillUnsupported is returned by Kill when cgroup.kill is unavailable,
+// which requires the unified hierarchy and Linux 5.14 or newer.
+var ErrKillUnsupported = errors.New("cgroup.kill is not supported")
+
 func NewHierarchy(root string) Hierarchy {
 
-	mode := cgroups.Mode()
+	mode := Mode()
 
 	var h Hierarchy
 
-	if mode == cgroups.Unified {
+	switch mode {
+	case cgroups.Unified:
 		h = &Unified{Root: root}
-	} else {
+	case cgroups.Hybrid:
+		h = &Hybrid{Legacy: Legacy{Root: root}}
+	default:
 		h = &Legacy{Root: root}
 	}
 
 	return h
 }
 
+// LogMode logs the cgroup mode detected, and where each hierarchy is
+// mounted.
+func LogMode() {
+	modes := map[cgroups.CGMode]string{
+		cgroups.Unavailable: "unavailable",
+		cgroups.Legacy:      "legacy",
+		cgroups.Hybrid:      "hybrid",
+		cgroups.Unified:     "unified",
+	}
+
+	var mountpoints []string
+	mounts, err := readMounts()
+	if err != nil {
+		slog.Warn("unable to read mounts", "err", err)
+	}
+	for _, m := range mounts {
+		if m.FSType == "cgroup" || m.FSType == "cgroup2" {
+			mountpoints = append(mountpoints, m.MountPoint)
+		}
+	}
+
+	slog.Info("Detected cgroup hierarchy", "mode", modes[Mode()], "mounts", mountpoints)
+}
+
+// readMounts returns the mounts of the warden, read from ProcPath.
+func readMounts() ([]*procfs.MountInfo, error) {
+	fs, err := procfs.NewFS(ProcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := fs.Self()
+	if err != nil {
+		return nil, err
+	}
+	return self.MountInfo()
+}
+
+// UnifiedPath returns the path of cg in the unified hierarchy, or false if
+// the unified hierarchy is not mounted. In hybrid mode, the unified hierarchy
+// tracks processes but has no controllers.
+func UnifiedPath(cg string) (string, bool) {
+	switch Mode() {
+	case cgroups.Unified:
+		return path.Join(MountPoint, cg), true
+	case cgroups.Hybrid:
+		return path.Join(hybridUnifiedRoot(), cg), true
+	}
+	return "", false
+}
+
 type CGroupInfo struct {
-	Username    string
+	Identity
 	MemoryUsage uint64
 	CPUUsage    float64
 	MemoryMax   uint64
+	SwapUsage   uint64
+	SwapMax     uint64
 	CPUQuota    int64
+	Frozen      bool
+	Pressure    map[string]Pressure
+	Created     time.Time
 }
 
-var uidRe = regexp.MustCompile(`user-(\d+)\.slice`)
+// resources with pressure stall information
+var pressureResources = []string{"cpu", "memory", "io"}
 
-// lookupUsername looks up a username given the systemd user slice name.
-// If compiled with CGO, this function will call the C function getpwuid_r
-// from the standard C library; This is necessary when user identities are
-// provided by services like sss and ldap.
-func lookupUsername(slice string) (string, error) {
-	match := uidRe.FindStringSubmatch(slice)
+// Pressure is the total time in seconds that some or all tasks of a cgroup
+// were stalled on a resource, keyed by some and full.
+type Pressure map[string]float64
 
-	if len(match) < 2 {
-		return "", fmt.Errorf("cannot determine uid from '%s'", slice)
+// readPressure reads the pressure stall information of the cgroup at dir in
+// the unified hierarchy, keyed by resource. It is nil if the kernel does not
+// support PSI.
+func readPressure(dir string) map[string]Pressure {
+	var pressure map[string]Pressure
+	for _, resource := range pressureResources {
+		buf, err := os.ReadFile(path.Join(dir, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+
+		// lines are like: some avg10=0.00 avg60=0.00 avg300=0.00 total=1234
+		p := make(Pressure)
+		for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			for _, field := range fields[1:] {
+				value, ok := strings.CutPrefix(field, "total=")
+				if !ok {
+					continue
+				}
+				if total, err := strconv.ParseUint(value, 10, 64); err == nil {
+					p[fields[0]] = float64(total) / USPerS
+				}
+			}
+		}
+
+		if pressure == nil {
+			pressure = make(map[string]Pressure)
+		}
+		pressure[resource] = p
+	}
+	return pressure
+}
+
+// quotaPerPeriod converts a CPU quota in micro seconds per second to micro
+// seconds per period, which the kernel requires to be at least 1ms.
+func quotaPerPeriod(quota int64, period uint64) int64 {
+	return max(quota*int64(period)/USPerS, 1000)
+}
+
+// start times are cached by the inode of the cgroup directory, as a cgroup
+// that is removed and created again gets a new inode. Failed lookups are
+// retried after startNegativeCacheTTL.
+const startNegativeCacheTTL = time.Minute
+
+type startEntry struct {
+	inode   uint64
+	start   time.Time
+	expires time.Time
+}
+
+var starts = struct {
+	data  map[string]startEntry
+	mutex sync.Mutex
+}{data: make(map[string]startEntry)}
+
+// createdTime returns when the unit of cg, whose cgroup directory is dir,
+// was last started, from its InactiveExitTimestamp in systemd. The unit
+// leaves the inactive state before its cgroup is created, so its counters
+// cannot have begun before then. cgroupfs does not record birth times, and
+// the change time of the directory moves whenever its attributes or children
+// change. The zero time is returned if the start time is unknown.
+func createdTime(ctx context.Context, cg string, dir string) time.Time {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		slog.Debug("unable to stat cgroup, creation time unknown", "path", dir, "err", err)
+		return time.Time{}
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+
+	starts.mutex.Lock()
+	entry, ok := starts.data[cg]
+	starts.mutex.Unlock()
+	if ok && entry.inode == stat.Ino && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return entry.start
 	}
 
-	user, err := user.LookupId(match[1])
+	entry = startEntry{inode: stat.Ino}
+	entry.start, err = unitStart(ctx, path.Base(cg))
 	if err != nil {
-		return "", fmt.Errorf("unable to lookup user with id '%s'", match[1])
+		slog.Debug("unable to look up unit start time, creation time unknown", "cgroup", cg, "err", err)
+		entry.expires = time.Now().Add(startNegativeCacheTTL)
 	}
 
-	return user.Username, nil
+	starts.mutex.Lock()
+	starts.data[cg] = entry
+	starts.mutex.Unlock()
+	return entry.start
+}
+
+func unitStart(ctx context.Context, unit string) (time.Time, error) {
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "InactiveExitTimestamp")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	usec, ok := prop.Value.Value().(uint64)
+	if !ok || usec == 0 {
+		return time.Time{}, fmt.Errorf("unit '%s' has not been started", unit)
+	}
+	return time.UnixMicro(int64(usec)), nil
+}
+
+// pidGroupPath returns the path of the unified hierarchy cgroup of pid, from
+// the 0:: entry of its cgroup file under ProcPath.
+func pidGroupPath(pid uint64) (string, error) {
+	p := path.Join(ProcPath, strconv.FormatUint(pid, 10), "cgroup")
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		if cg, ok := strings.CutPrefix(line, "0::"); ok {
+			return cg, nil
+		}
+	}
+	return "", fmt.Errorf("no unified hierarchy entry in '%s'", p)
 }
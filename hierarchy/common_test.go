@@ -0,0 +1,214 @@
+package hierarchy
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/containerd/cgroups/v3"
+)
+
+// fixture points MountPoint and ProcPath at empty directories, restoring them
+// when the test ends.
+func fixture(t *testing.T) (string, string) {
+	t.Helper()
+	mount, proc := t.TempDir(), t.TempDir()
+	savedMount, savedProc := MountPoint, ProcPath
+	MountPoint, ProcPath = mount, proc
+	t.Cleanup(func() { MountPoint, ProcPath = savedMount, savedProc })
+	return mount, proc
+}
+
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModeFixture(t *testing.T) {
+	fixture(t)
+
+	// a plain directory is not cgroupfs, and is treated as legacy like
+	// cgroups.Mode does
+	if mode := Mode(); mode != cgroups.Legacy {
+		t.Errorf("Mode() = %v, want legacy", mode)
+	}
+	if _, ok := UnifiedPath("/user.slice"); ok {
+		t.Errorf("UnifiedPath reported a unified hierarchy on a legacy fixture")
+	}
+}
+
+func TestPIDGroupPathFixture(t *testing.T) {
+	_, proc := fixture(t)
+	writeFile(t, path.Join(proc, "42", "cgroup"), "12:cpuacct:/user.slice\n0::/user.slice/user-1000.slice/session-3.scope\n")
+
+	cg, err := pidGroupPath(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cg != "/user.slice/user-1000.slice/session-3.scope" {
+		t.Errorf("got %q", cg)
+	}
+
+	writeFile(t, path.Join(proc, "43", "cgroup"), "12:cpuacct:/user.slice\n")
+	if _, err := pidGroupPath(43); err == nil {
+		t.Errorf("expected an error without a unified hierarchy entry")
+	}
+}
+
+func TestReadCPUQuotaFixture(t *testing.T) {
+	mount, _ := fixture(t)
+	cg := "/user.slice/user-1000.slice"
+
+	writeFile(t, path.Join(mount, cg, "cpu.max"), "200000 100000\n")
+	if quota := readCPUQuotaUnified(cg); quota != 2*USPerS {
+		t.Errorf("unified quota = %d, want %d", quota, 2*USPerS)
+	}
+
+	writeFile(t, path.Join(mount, cg, "cpu.max"), "max 100000\n")
+	if quota := readCPUQuotaUnified(cg); quota != -1 {
+		t.Errorf("unlimited unified quota = %d, want -1", quota)
+	}
+
+	writeFile(t, path.Join(mount, "cpu", cg, "cpu.cfs_quota_us"), "50000\n")
+	writeFile(t, path.Join(mount, "cpu", cg, "cpu.cfs_period_us"), "100000\n")
+	if quota := readCPUQuotaLegacy(cg); quota != USPerS/2 {
+		t.Errorf("legacy quota = %d, want %d", quota, USPerS/2)
+	}
+
+	writeFile(t, path.Join(mount, "cpu", cg, "cpu.cfs_quota_us"), "-1\n")
+	if quota := readCPUQuotaLegacy(cg); quota != -1 {
+		t.Errorf("unlimited legacy quota = %d, want -1", quota)
+	}
+}
+
+func TestReadFrozenUnifiedFixture(t *testing.T) {
+	mount, _ := fixture(t)
+	cg := "/user.slice/user-1000.slice"
+
+	writeFile(t, path.Join(mount, cg, "cgroup.events"), "populated 1\nfrozen 1\n")
+	if !readFrozenUnified(cg) {
+		t.Errorf("frozen cgroup reported as thawed")
+	}
+
+	writeFile(t, path.Join(mount, cg, "cgroup.events"), "populated 1\nfrozen 0\n")
+	if readFrozenUnified(cg) {
+		t.Errorf("thawed cgroup reported as frozen")
+	}
+}
+
+func TestJoinFrozenFixture(t *testing.T) {
+	mount, _ := fixture(t)
+	l := &Legacy{Root: "/user.slice"}
+	unit := "user-1000.slice"
+	cg := path.Join(l.Root, unit)
+
+	writeFile(t, path.Join(mount, "cpuacct", cg, "cgroup.procs"), "100\n101\n")
+	writeFile(t, path.Join(mount, "freezer", cg, "cgroup.procs"), "100\n")
+	writeFile(t, path.Join(mount, "freezer", cg, "freezer.state"), "FROZEN\n")
+
+	// 101 joined after the unit was frozen, and is moved into the freezer
+	frozen, err := l.JoinFrozen(unit)
+	if err != nil || !frozen {
+		t.Fatalf("JoinFrozen() = %v, %v, want true", frozen, err)
+	}
+	buf, err := os.ReadFile(path.Join(mount, "freezer", cg, "cgroup.procs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(buf)); got != "101" {
+		t.Errorf("last pid written to the freezer = %q, want 101", got)
+	}
+
+	// a unit thawed by other means is left alone
+	writeFile(t, path.Join(mount, "freezer", cg, "cgroup.procs"), "")
+	writeFile(t, path.Join(mount, "freezer", cg, "freezer.state"), "THAWED\n")
+	frozen, err = l.JoinFrozen(unit)
+	if err != nil || frozen {
+		t.Fatalf("JoinFrozen() = %v, %v on a thawed unit, want false", frozen, err)
+	}
+	if buf, _ := os.ReadFile(path.Join(mount, "freezer", cg, "cgroup.procs")); len(buf) != 0 {
+		t.Errorf("processes were moved into a thawed freezer cgroup: %q", buf)
+	}
+}
+
+func TestUnifiedFixture(t *testing.T) {
+	mount, proc := fixture(t)
+	defer func(mode cgroups.CGMode) { ForceMode = mode }(ForceMode)
+	ForceMode = cgroups.Unified
+
+	cg := "/user.slice/user-0.slice"
+	files := map[string]string{
+		"cgroup.procs":                              "",
+		"user-0.slice/cgroup.procs":                 "",
+		"user-0.slice/session-1.scope/cgroup.procs": "42\n",
+		"user-0.slice/cpu.stat":                     "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		"user-0.slice/cpu.max":                      "100000 100000\n",
+		"user-0.slice/memory.current":               "1048576\n",
+		"user-0.slice/memory.max":                   "2097152\n",
+		"user-0.slice/memory.swap.current":          "4096\n",
+		"user-0.slice/memory.swap.max":              "max\n",
+		"user-0.slice/memory.stat":                  "anon 0\n",
+		"user-0.slice/cgroup.events":                "populated 1\nfrozen 1\n",
+		"user-0.slice/memory.pressure":              "some avg10=0.00 avg60=0.00 avg300=0.00 total=1500000\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=500000\n",
+	}
+	for file, content := range files {
+		writeFile(t, path.Join(mount, "user.slice", file), content)
+	}
+	writeFile(t, path.Join(proc, "42", "cgroup"), "0::"+cg+"/session-1.scope\n")
+
+	h := NewHierarchy("/user.slice")
+	if _, ok := h.(*Unified); !ok {
+		t.Fatalf("NewHierarchy() = %T, want *Unified", h)
+	}
+	if p, ok := UnifiedPath(cg); !ok || p != path.Join(mount, cg) {
+		t.Errorf("UnifiedPath() = %q, %v, want %q", p, ok, path.Join(mount, cg))
+	}
+
+	groups, err := h.GetGroupsWithPIDs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || !groups[cg][42] {
+		t.Errorf("GetGroupsWithPIDs() = %v, want pid 42 in %s", groups, cg)
+	}
+
+	info, err := h.CGroupInfo(context.Background(), cg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CPUUsage != 2.5 || info.CPUQuota != USPerS {
+		t.Errorf("got cpu usage %v quota %d, want 2.5 and %d", info.CPUUsage, info.CPUQuota, USPerS)
+	}
+	if info.MemoryUsage != 1048576 || info.MemoryMax != 2097152 || info.SwapUsage != 4096 {
+		t.Errorf("got memory %d max %d swap %d", info.MemoryUsage, info.MemoryMax, info.SwapUsage)
+	}
+	if !info.Frozen || info.Pressure["memory"]["some"] != 1.5 {
+		t.Errorf("got frozen %v pressure %v", info.Frozen, info.Pressure)
+	}
+	if info.UID != "0" {
+		t.Errorf("got uid %q, want 0", info.UID)
+	}
+}
+
+func TestReadMountsFixture(t *testing.T) {
+	_, proc := fixture(t)
+	writeFile(t, path.Join(proc, "42", "mountinfo"), "35 24 0:30 / /host/sys/fs/cgroup rw,nosuid shared:9 - cgroup2 cgroup2 rw,nsdelegate\n")
+	if err := os.Symlink("42", path.Join(proc, "self")); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 || mounts[0].MountPoint != "/host/sys/fs/cgroup" || mounts[0].FSType != "cgroup2" {
+		t.Errorf("got %+v, want the cgroup2 mount of the fixture", mounts)
+	}
+}
@@ -0,0 +1,41 @@
+package hierarchy
+
+import (
+	"context"
+	"path"
+
+	"github.com/containerd/cgroups/v3/cgroup2"
+)
+
+// Hybrid is the hybrid mode of systemd, where controllers are mounted in the
+// legacy hierarchy and the unified hierarchy is mounted alongside them
+// without controllers. Usage and limits are read from the legacy hierarchy,
+// and process membership and pressure from the unified one.
+type Hybrid struct {
+	Legacy
+}
+
+func (h *Hybrid) GetGroupsWithPIDs(ctx context.Context) (map[string]map[uint64]bool, error) {
+	return groupsWithPIDs(ctx, h.Root, cgroup2.WithMountpoint(hybridUnifiedRoot()))
+}
+
+func (h *Hybrid) CGroupInfo(ctx context.Context, cg string) (CGroupInfo, error) {
+	info, err := h.Legacy.CGroupInfo(ctx, cg)
+	if err != nil {
+		return info, err
+	}
+
+	info.Pressure = readPressure(path.Join(hybridUnifiedRoot(), cg))
+	return info, nil
+}
+
+// GetPIDs returns the processes in unit and its descendants.
+func (h *Hybrid) GetPIDs(unit string) (map[uint64]bool, error) {
+	return unitPIDs(path.Join(h.Root, unit), cgroup2.WithMountpoint(hybridUnifiedRoot()))
+}
+
+// Kill kills every process in unit and its descendants with cgroup.kill in
+// the unified hierarchy, where the kernel supports it.
+func (h *Hybrid) Kill(unit string) error {
+	return kill(path.Join(hybridUnifiedRoot(), h.Root, unit))
+}
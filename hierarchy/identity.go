@@ -0,0 +1,230 @@
+package hierarchy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/user"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+var uidRe = regexp.MustCompile(`user-(\d+)\.slice`)
+
+// identity lookups are cached, as resolving a uid may require a round trip
+// to sss or ldap for every group on every scrape.
+var (
+	UsernameCacheTTL         = 10 * time.Minute
+	UsernameNegativeCacheTTL = time.Minute
+	UsernameLookupTimeout    = 2 * time.Second
+)
+
+// SupplementaryGroups is the allowlist of supplementary group names reported
+// for each user. Group memberships not in this list are not reported.
+var SupplementaryGroups []string
+
+// Identity describes the user owning a systemd user slice.
+type Identity struct {
+	Username string
+	UID      string
+	GID      string
+	Group    string
+	Groups   []string
+}
+
+var identities = newIdentityCache()
+
+type identityEntry struct {
+	identity Identity
+	found    bool
+	expires  time.Time
+}
+
+type identityCache struct {
+	data    map[string]identityEntry
+	pending map[string]chan struct{}
+	mutex   sync.Mutex
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{
+		data:    make(map[string]identityEntry),
+		pending: make(map[string]chan struct{}),
+		mutex:   sync.Mutex{},
+	}
+}
+
+// get returns the identity for uid, resolving it if the cached value is
+// missing or expired. Expired identities are served while they are refreshed
+// in the background. If an unknown uid cannot be resolved within
+// UsernameLookupTimeout or before ctx is done, an identity carrying only the
+// numeric uid is returned instead.
+func (ic *identityCache) get(ctx context.Context, uid string) Identity {
+	ic.mutex.Lock()
+	e, ok := ic.data[uid]
+	if ok && time.Now().Before(e.expires) {
+		ic.mutex.Unlock()
+		return e.identity
+	}
+
+	done := ic.refresh(uid)
+	ic.mutex.Unlock()
+
+	// serve the stale identity while it is refreshed in the background
+	if ok && e.found {
+		return e.identity
+	}
+
+	select {
+	case <-done:
+	case <-time.After(UsernameLookupTimeout):
+		slog.Warn("timed out looking up username", "uid", uid)
+	case <-ctx.Done():
+		slog.Warn("gave up looking up username", "uid", uid, "err", ctx.Err())
+	}
+
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	if e, ok := ic.data[uid]; ok && e.found {
+		return e.identity
+	}
+	return Identity{Username: uid, UID: uid}
+}
+
+// cached returns the identity for uid without waiting on a lookup. Missing
+// and expired identities are resolved in the background, and an identity
+// carrying only the numeric uid is returned until one has been resolved.
+func (ic *identityCache) cached(uid string) Identity {
+	defer ic.mutex.Unlock()
+	ic.mutex.Lock()
+	e, ok := ic.data[uid]
+	if !ok || !time.Now().Before(e.expires) {
+		ic.refresh(uid)
+	}
+	if ok && e.found {
+		return e.identity
+	}
+	return Identity{Username: uid, UID: uid}
+}
+
+// refresh starts resolving uid in the background, unless a lookup is
+// already in flight, returning a channel closed once it completes. ic.mutex
+// must be held.
+func (ic *identityCache) refresh(uid string) chan struct{} {
+	// only one lookup per uid is in flight at a time
+	done, inflight := ic.pending[uid]
+	if !inflight {
+		done = make(chan struct{})
+		ic.pending[uid] = done
+		go ic.resolve(uid, done)
+	}
+	return done
+}
+
+func (ic *identityCache) resolve(uid string, done chan struct{}) {
+	entry := identityEntry{identity: Identity{Username: uid, UID: uid}}
+	identity, err := resolveIdentity(uid)
+	switch {
+	case err == nil:
+		entry.identity = identity
+		entry.found = true
+		entry.expires = time.Now().Add(UsernameCacheTTL)
+	case errors.As(err, new(user.UnknownUserIdError)):
+		slog.Debug("unknown user id, exporting numeric uid", "uid", uid)
+		entry.expires = time.Now().Add(UsernameNegativeCacheTTL)
+	default:
+		slog.Warn("unable to lookup username", "uid", uid, "err", err)
+		entry.expires = time.Now().Add(UsernameNegativeCacheTTL)
+	}
+
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	// keep a previously resolved identity around rather than replacing it
+	// with the uid when the lookup service is unavailable
+	if old, ok := ic.data[uid]; ok && old.found && !entry.found {
+		entry.identity = old.identity
+		entry.found = true
+	}
+	ic.data[uid] = entry
+	delete(ic.pending, uid)
+	close(done)
+}
+
+// resolveIdentity looks up the user, primary group and allowlisted
+// supplementary groups of uid. Failure to resolve a group name is not fatal;
+// the numeric gid is reported instead.
+func resolveIdentity(uid string) (Identity, error) {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Username: u.Username,
+		UID:      u.Uid,
+		GID:      u.Gid,
+		Group:    lookupGroupName(u.Gid),
+	}
+
+	if len(SupplementaryGroups) == 0 {
+		return identity, nil
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		slog.Warn("unable to lookup supplementary groups", "uid", uid, "err", err)
+		return identity, nil
+	}
+
+	for _, gid := range gids {
+		name := lookupGroupName(gid)
+		if slices.Contains(SupplementaryGroups, name) && !slices.Contains(identity.Groups, name) {
+			identity.Groups = append(identity.Groups, name)
+		}
+	}
+	slices.Sort(identity.Groups)
+
+	return identity, nil
+}
+
+func lookupGroupName(gid string) string {
+	group, err := user.LookupGroupId(gid)
+	if err != nil {
+		slog.Debug("unable to lookup group name", "gid", gid, "err", err)
+		return gid
+	}
+	return group.Name
+}
+
+// LookupIdentity looks up the owner of the systemd user slice name.
+// If compiled with CGO, this function will call the C function getpwuid_r
+// from the standard C library; This is necessary when user identities are
+// provided by services like sss and ldap. Results are cached, and an
+// identity carrying only the numeric uid is returned if the user cannot be
+// resolved.
+func LookupIdentity(ctx context.Context, slice string) (Identity, error) {
+	match := uidRe.FindStringSubmatch(slice)
+
+	if len(match) < 2 {
+		return Identity{}, fmt.Errorf("cannot determine uid from '%s'", slice)
+	}
+
+	return identities.get(ctx, match[1]), nil
+}
+
+// CachedIdentity looks up the owner of the systemd user slice name like
+// LookupIdentity, but never waits on the lookup service. Until the owner has
+// been resolved in the background, an identity carrying only the numeric uid
+// is returned.
+func CachedIdentity(slice string) (Identity, error) {
+	match := uidRe.FindStringSubmatch(slice)
+
+	if len(match) < 2 {
+		return Identity{}, fmt.Errorf("cannot determine uid from '%s'", slice)
+	}
+
+	return identities.cached(match[1]), nil
+}
@@ -0,0 +1,31 @@
+package hierarchy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdentityCacheCached(t *testing.T) {
+	ic := newIdentityCache()
+
+	// nothing is cached yet, so the uid is returned without waiting
+	if got := ic.cached("0"); got.Username != "0" || got.UID != "0" {
+		t.Errorf("got %+v before the lookup, want the numeric uid", got)
+	}
+
+	ic.mutex.Lock()
+	done, ok := ic.pending["0"]
+	ic.mutex.Unlock()
+	if !ok {
+		t.Fatalf("no lookup was started")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the lookup")
+	}
+
+	if got := ic.cached("0"); got.Username != "root" {
+		t.Errorf("got username %q after the lookup, want root", got.Username)
+	}
+}
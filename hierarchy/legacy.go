@@ -1,14 +1,19 @@
 package hierarchy
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"log/slog"
 	"math"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/containerd/cgroups/v3/cgroup1"
+	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
@@ -16,16 +21,19 @@ type Legacy struct {
 	Root string
 }
 
-func (l *Legacy) SetMemoryLimits(unit string, limit int64) (int64, error) {
+// SetMemoryLimits sets the memory limit of unit, and the limit of memory and
+// swap combined to the same value, so the unit cannot swap. It returns the
+// memory limit written, and -1 for swap, as swap is not limited on its own.
+func (l *Legacy) SetMemoryLimits(unit string, limit int64) (int64, int64, error) {
 	cgroup := path.Join(l.Root, unit)
 	manager, err := cgroup1.Load(cgroup1.StaticPath(cgroup), cgroup1.WithHierarchy(subsystem))
 	if err != nil {
-		return -1, err
+		return -1, -1, err
 	}
 
 	stat, err := manager.Stat(cgroup1.IgnoreNotExist)
 	if err != nil || stat == nil || stat.Memory == nil {
-		return -1, err
+		return -1, -1, err
 	}
 
 	newLimit := max(limit, int64(stat.Memory.Swap.Usage+LimitBuffer))
@@ -38,10 +46,43 @@ func (l *Legacy) SetMemoryLimits(unit string, limit int64) (int64, error) {
 	}
 
 	err = manager.Update(resources)
-	return newLimit, err
+	return newLimit, -1, err
+}
+
+// SetMemoryHigh is unsupported, as the legacy hierarchy has no throttling
+// limit.
+func (l *Legacy) SetMemoryHigh(unit string, limit int64) (int64, error) {
+	return -1, errors.New("memory high is not supported on the legacy hierarchy")
+}
+
+// SetMemoryLow sets the soft limit of unit, which memory is reclaimed down to
+// under pressure. It is clamped to the memory limit of the unit, as more
+// cannot be used.
+func (l *Legacy) SetMemoryLow(unit string, limit int64) (int64, error) {
+	cgroup := path.Join(l.Root, unit)
+	manager, err := cgroup1.Load(cgroup1.StaticPath(cgroup), cgroup1.WithHierarchy(subsystem))
+	if err != nil {
+		return -1, err
+	}
+
+	stat, err := manager.Stat(cgroup1.IgnoreNotExist)
+	if err != nil || stat == nil || stat.Memory == nil || stat.Memory.Usage == nil {
+		return -1, err
+	}
+
+	newLow := min(limit, int64(min(stat.Memory.Usage.Limit, MaxCGroupMemoryLimit)))
+
+	resources := &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{
+			Reservation: &newLow,
+		},
+	}
+
+	err = manager.Update(resources)
+	return newLow, err
 }
 
-func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
+func (l *Legacy) GetGroupsWithPIDs(ctx context.Context) (map[string]map[uint64]bool, error) {
 
 	var pids = make(map[string]map[uint64]bool)
 
@@ -56,8 +97,17 @@ func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	}
 
 	for _, p := range procs {
-		dirs := strings.Split(p.Path, "/")
-		group := "/" + strings.Join(dirs[5:7], "/")
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		rel := strings.TrimPrefix(p.Path, path.Join(MountPoint, "cpuacct"))
+		dirs := strings.Split(rel, "/")
+		if len(dirs) < 3 {
+			// the process is in the root itself rather than a child of it
+			continue
+		}
+		group := strings.Join(dirs[0:3], "/")
 
 		groupPids, ok := pids[group]
 		if !ok {
@@ -71,7 +121,7 @@ func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	return pids, nil
 }
 
-func (l *Legacy) CGroupInfo(cg string) (CGroupInfo, error) {
+func (l *Legacy) CGroupInfo(ctx context.Context, cg string) (CGroupInfo, error) {
 	var info CGroupInfo
 
 	manager, err := cgroup1.Load(cgroup1.StaticPath(cg), cgroup1.WithHierarchy(subsystem))
@@ -92,27 +142,224 @@ func (l *Legacy) CGroupInfo(cg string) (CGroupInfo, error) {
 	if stat.Memory != nil {
 		info.MemoryUsage = stat.Memory.TotalRSS
 		info.MemoryMax = stat.Memory.Usage.Limit
+		info.SwapUsage, info.SwapMax = legacySwap(stat.Memory.Usage, stat.Memory.Swap)
 	}
 
-	username, err := lookupUsername(cg)
+	info.Frozen = readFrozenLegacy(cg, manager)
+	info.Created = createdTime(ctx, cg, path.Join(MountPoint, "cpuacct", cg))
+
+	identity, err := LookupIdentity(ctx, cg)
 	if err != nil {
 		return info, err
 	}
 
-	info.Username = username
+	info.Identity = identity
 	return info, nil
 }
 
+// legacySwap derives swap usage and limit from the memsw counters, which on
+// the legacy hierarchy account for memory and swap combined.
+func legacySwap(memory, memsw *v1.MemoryEntry) (uint64, uint64) {
+	if memory == nil || memsw == nil {
+		return 0, MaxCGroupMemoryLimit
+	}
+
+	var usage uint64
+	if memsw.Usage > memory.Usage {
+		usage = memsw.Usage - memory.Usage
+	}
+
+	limit := memsw.Limit
+	if memsw.Limit < MaxCGroupMemoryLimit && memsw.Limit > memory.Limit {
+		limit = memsw.Limit - memory.Limit
+	} else if memsw.Limit < MaxCGroupMemoryLimit {
+		limit = 0
+	}
+
+	return usage, limit
+}
+
+// SetCPUQuota sets the CPU quota of unit in micro seconds per second with
+// cpu.cfs_quota_us and cpu.cfs_period_us. A negative quota removes the limit.
+func (l *Legacy) SetCPUQuota(unit string, quota int64) error {
+	cgroup := path.Join(l.Root, unit)
+	manager, err := cgroup1.Load(cgroup1.StaticPath(cgroup), cgroup1.WithHierarchy(cpuSubsystem))
+	if err != nil {
+		return err
+	}
+
+	period := uint64(CPUPeriod)
+	perPeriod := int64(-1)
+	if quota >= 0 {
+		perPeriod = quotaPerPeriod(quota, period)
+	}
+
+	resources := &specs.LinuxResources{
+		CPU: &specs.LinuxCPU{
+			Quota:  &perPeriod,
+			Period: &period,
+		},
+	}
+	return manager.Update(resources)
+}
+
+// SetFrozen freezes or thaws every process in unit with the freezer
+// controller. systemd does not manage the freezer hierarchy, so the freezer
+// cgroup of unit is created on first use, and the processes of unit are
+// moved into it. Processes forked while this happens are moved once the
+// cgroup is frozen, which freezes them too.
+func (l *Legacy) SetFrozen(unit string, frozen bool) error {
+	cg := path.Join(l.Root, unit)
+
+	freezer, err := cgroup1.Load(cgroup1.StaticPath(cg), cgroup1.WithHierarchy(freezerSubsystem))
+	if errors.Is(err, cgroup1.ErrCgroupDeleted) {
+		if !frozen {
+			return nil
+		}
+		freezer, err = cgroup1.New(cgroup1.StaticPath(cg), &specs.LinuxResources{}, cgroup1.WithHierarchy(freezerSubsystem))
+	}
+	if err != nil {
+		return err
+	}
+
+	if !frozen {
+		return freezer.Thaw()
+	}
+
+	err = moveToFreezer(cg, freezer)
+	if err != nil {
+		return err
+	}
+
+	err = freezer.Freeze()
+	if err != nil {
+		return err
+	}
+
+	return moveToFreezer(cg, freezer)
+}
+
+// JoinFrozen moves the processes placed in unit since it was frozen into its
+// freezer cgroup, which freezes them too. If the freezer cgroup of unit is
+// no longer frozen, as when it was thawed by other means, no process is
+// moved and false is returned.
+func (l *Legacy) JoinFrozen(unit string) (bool, error) {
+	cg := path.Join(l.Root, unit)
+
+	freezer, err := cgroup1.Load(cgroup1.StaticPath(cg), cgroup1.WithHierarchy(freezerSubsystem))
+	if err != nil {
+		return false, err
+	}
+
+	buf, err := os.ReadFile(path.Join(MountPoint, "freezer", cg, "freezer.state"))
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(string(buf)) {
+	case "FROZEN", "FREEZING":
+	default:
+		return false, nil
+	}
+
+	return true, moveToFreezer(cg, freezer)
+}
+
+// moveToFreezer adds every process of cg to the freezer cgroup.
+func moveToFreezer(cg string, freezer cgroup1.Cgroup) error {
+	manager, err := cgroup1.Load(cgroup1.StaticPath(cg), cgroup1.WithHierarchy(subsystem))
+	if err != nil {
+		return err
+	}
+
+	procs, err := manager.Processes(cgroup1.Cpuacct, true)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		err := freezer.AddProc(uint64(p.Pid), cgroup1.Freezer)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPIDs returns the processes in unit and its descendants.
+func (l *Legacy) GetPIDs(unit string) (map[uint64]bool, error) {
+	manager, err := cgroup1.Load(cgroup1.StaticPath(path.Join(l.Root, unit)), cgroup1.WithHierarchy(subsystem))
+	if err != nil {
+		return nil, err
+	}
+
+	procs, err := manager.Processes(cgroup1.Cpuacct, true)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make(map[uint64]bool, len(procs))
+	for _, p := range procs {
+		pids[uint64(p.Pid)] = true
+	}
+	return pids, nil
+}
+
+// Kill is unsupported, as cgroup.kill only exists on the unified hierarchy.
+func (l *Legacy) Kill(unit string) error {
+	return ErrKillUnsupported
+}
+
+// readFrozenLegacy reports whether the freezer cgroup of cg is frozen, and
+// holds every process of cg. Processes placed in cg after it was frozen are
+// not frozen until they are moved into the freezer cgroup, so until then cg
+// is not reported as frozen. A missing freezer cgroup has never been frozen.
+func readFrozenLegacy(cg string, manager cgroup1.Cgroup) bool {
+	dir := path.Join(MountPoint, "freezer", cg)
+	buf, err := os.ReadFile(path.Join(dir, "freezer.state"))
+	if err != nil || strings.TrimSpace(string(buf)) != "FROZEN" {
+		return false
+	}
+
+	procs, err := manager.Processes(cgroup1.Cpuacct, true)
+	if err != nil {
+		return false
+	}
+
+	buf, err = os.ReadFile(path.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return false
+	}
+	frozen := make(map[string]bool)
+	for _, pid := range strings.Fields(string(buf)) {
+		frozen[pid] = true
+	}
+
+	for _, p := range procs {
+		if !frozen[strconv.Itoa(p.Pid)] {
+			return false
+		}
+	}
+	return true
+}
+
+func cpuSubsystem() ([]cgroup1.Subsystem, error) {
+	return []cgroup1.Subsystem{cgroup1.NewCpu(MountPoint)}, nil
+}
+
+func freezerSubsystem() ([]cgroup1.Subsystem, error) {
+	return []cgroup1.Subsystem{cgroup1.NewFreezer(MountPoint)}, nil
+}
+
 func subsystem() ([]cgroup1.Subsystem, error) {
 	s := []cgroup1.Subsystem{
-		cgroup1.NewCpuacct(cgroupRoot),
-		cgroup1.NewMemory(cgroupRoot),
+		cgroup1.NewCpuacct(MountPoint),
+		cgroup1.NewMemory(MountPoint),
 	}
 	return s, nil
 }
 
 func readCPUQuotaLegacy(cg string) int64 {
-	cgroupPath := path.Join("/sys/fs/cgroup/cpu", cg)
+	cgroupPath := path.Join(MountPoint, "cpu", cg)
 	pathQuota := path.Join(cgroupPath, "cpu.cfs_quota_us")
 	pathPeriod := path.Join(cgroupPath, "cpu.cfs_period_us")
 
@@ -1,6 +1,9 @@
 package hierarchy
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"log/slog"
 	"math"
 	"os"
@@ -15,11 +18,18 @@ type Unified struct {
 	Root string
 }
 
-func (u *Unified) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
+func (u *Unified) GetGroupsWithPIDs(ctx context.Context) (map[string]map[uint64]bool, error) {
+	return groupsWithPIDs(ctx, u.Root, cgroup2.WithMountpoint(MountPoint))
+}
+
+// groupsWithPIDs returns the pids under root in the unified hierarchy,
+// grouped by the child of root they belong to. The unified hierarchy may be
+// mounted elsewhere, as in hybrid mode.
+func groupsWithPIDs(ctx context.Context, root string, opts ...cgroup2.InitOpts) (map[string]map[uint64]bool, error) {
 
 	var pids = make(map[string]map[uint64]bool)
 
-	manager, err := cgroup2.Load(u.Root)
+	manager, err := cgroup2.Load(root, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -30,12 +40,20 @@ func (u *Unified) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	}
 
 	for _, p := range procs {
-		path, err := cgroup2.PidGroupPath(int(p))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		path, err := pidGroupPath(p)
 		if err != nil {
 			slog.Info("could not determine cgroup of pid", "pid", p, "err", err)
 			continue
 		}
 		dirs := strings.Split(path, "/")
+		if len(dirs) < 3 {
+			// the process is in the root itself rather than a child of it
+			continue
+		}
 		group := strings.Join(dirs[0:3], "/")
 
 		groupPids, ok := pids[group]
@@ -50,10 +68,10 @@ func (u *Unified) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	return pids, nil
 }
 
-func (u *Unified) CGroupInfo(cg string) (CGroupInfo, error) {
+func (u *Unified) CGroupInfo(ctx context.Context, cg string) (CGroupInfo, error) {
 	var info CGroupInfo
 
-	manager, err := cgroup2.Load(cg)
+	manager, err := cgroup2.Load(cg, cgroup2.WithMountpoint(MountPoint))
 	if err != nil {
 		return info, err
 	}
@@ -71,28 +89,37 @@ func (u *Unified) CGroupInfo(cg string) (CGroupInfo, error) {
 	if stat.Memory != nil {
 		info.MemoryUsage = stat.Memory.Usage
 		info.MemoryMax = stat.Memory.UsageLimit
+		info.SwapUsage = stat.Memory.SwapUsage
+		info.SwapMax = stat.Memory.SwapLimit
 	}
 
-	username, err := lookupUsername(cg)
+	info.Frozen = readFrozenUnified(cg)
+	info.Pressure = readPressure(path.Join(MountPoint, cg))
+	info.Created = createdTime(ctx, cg, path.Join(MountPoint, cg))
+
+	identity, err := LookupIdentity(ctx, cg)
 	if err != nil {
 		return info, err
 	}
 
-	info.Username = username
+	info.Identity = identity
 	return info, nil
 }
 
 var SwapRatio float64 = 0.1
 
-func (u *Unified) SetMemoryLimits(unit string, limit int64) (int64, error) {
-	manager, err := cgroup2.Load(path.Join(u.Root, unit))
+// SetMemoryLimits sets the memory limit of unit, clamped to its current
+// usage, and its swap limit to SwapRatio of limit. It returns the memory and
+// swap limits written.
+func (u *Unified) SetMemoryLimits(unit string, limit int64) (int64, int64, error) {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
 	if err != nil {
-		return -1, err
+		return -1, -1, err
 	}
 
 	stat, err := manager.Stat()
 	if err != nil || stat == nil || stat.Memory == nil {
-		return -1, err
+		return -1, -1, err
 	}
 
 	newMax := max(limit, int64(stat.Memory.Usage+LimitBuffer))
@@ -106,11 +133,154 @@ func (u *Unified) SetMemoryLimits(unit string, limit int64) (int64, error) {
 	}
 
 	err = manager.Update(resources)
-	return newMax, err
+	return newMax, newSwap, err
+}
+
+// SetMemoryHigh sets the throttling limit of unit, which is clamped to the
+// current usage like SetMemoryLimits, so that reclaim is not forced on all of
+// the memory of the unit at once.
+func (u *Unified) SetMemoryHigh(unit string, limit int64) (int64, error) {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
+	if err != nil {
+		return -1, err
+	}
+
+	stat, err := manager.Stat()
+	if err != nil || stat == nil || stat.Memory == nil {
+		return -1, err
+	}
+
+	newHigh := max(limit, int64(stat.Memory.Usage+LimitBuffer))
+
+	resources := &cgroup2.Resources{
+		Memory: &cgroup2.Memory{
+			High: &newHigh,
+		},
+	}
+
+	err = manager.Update(resources)
+	return newHigh, err
+}
+
+// SetMemoryLow sets the memory protection of unit, which is clamped to the
+// memory limit of the unit, as more cannot be used.
+func (u *Unified) SetMemoryLow(unit string, limit int64) (int64, error) {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
+	if err != nil {
+		return -1, err
+	}
+
+	stat, err := manager.Stat()
+	if err != nil || stat == nil || stat.Memory == nil {
+		return -1, err
+	}
+
+	newLow := min(limit, int64(min(stat.Memory.UsageLimit, MaxCGroupMemoryLimit)))
+
+	resources := &cgroup2.Resources{
+		Memory: &cgroup2.Memory{
+			Low: &newLow,
+		},
+	}
+
+	err = manager.Update(resources)
+	return newLow, err
+}
+
+// SetCPUQuota sets the CPU quota of unit in micro seconds per second with
+// cpu.max. A negative quota removes the limit.
+func (u *Unified) SetCPUQuota(unit string, quota int64) error {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
+	if err != nil {
+		return err
+	}
+
+	period := uint64(CPUPeriod)
+	var max *int64
+	if quota >= 0 {
+		perPeriod := quotaPerPeriod(quota, period)
+		max = &perPeriod
+	}
+
+	resources := &cgroup2.Resources{
+		CPU: &cgroup2.CPU{
+			Max: cgroup2.NewCPUMax(max, &period),
+		},
+	}
+	return manager.Update(resources)
+}
+
+// SetFrozen freezes or thaws every process in unit with cgroup.freeze.
+func (u *Unified) SetFrozen(unit string, frozen bool) error {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
+	if err != nil {
+		return err
+	}
+
+	if frozen {
+		return manager.Freeze()
+	}
+	return manager.Thaw()
+}
+
+// GetPIDs returns the processes in unit and its descendants.
+func (u *Unified) GetPIDs(unit string) (map[uint64]bool, error) {
+	return unitPIDs(path.Join(u.Root, unit), cgroup2.WithMountpoint(MountPoint))
+}
+
+func unitPIDs(cg string, opts ...cgroup2.InitOpts) (map[uint64]bool, error) {
+	manager, err := cgroup2.Load(cg, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	procs, err := manager.Procs(true)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make(map[uint64]bool, len(procs))
+	for _, p := range procs {
+		pids[p] = true
+	}
+	return pids, nil
+}
+
+// Kill kills every process in unit and its descendants with cgroup.kill.
+func (u *Unified) Kill(unit string) error {
+	return kill(path.Join(MountPoint, u.Root, unit))
+}
+
+// kill writes cgroup.kill in dir, the path of a cgroup in the unified
+// hierarchy.
+func kill(dir string) error {
+	file := path.Join(dir, "cgroup.kill")
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		return ErrKillUnsupported
+	}
+	return os.WriteFile(file, []byte("1"), 0)
+}
+
+// readFrozenUnified reports whether cg has been frozen, according to the
+// frozen key of cgroup.events.
+func readFrozenUnified(cg string) bool {
+	buf, err := os.ReadFile(path.Join(MountPoint, cg, "cgroup.events"))
+	if err != nil {
+		slog.Debug("unable to read cgroup events, assuming cgroup is not frozen", "err", err)
+		return false
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		if key == "frozen" {
+			return value == "1"
+		}
+	}
+	return false
 }
 
 func readCPUQuotaUnified(cg string) int64 {
-	cgroupPath := path.Join("/sys/fs/cgroup", cg)
+	cgroupPath := path.Join(MountPoint, cg)
 	p := path.Join(cgroupPath, "cpu.max")
 	buf, err := os.ReadFile(p)
 	if err != nil {
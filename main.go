@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 
+	"github.com/chpc-uofu/cgroup-warden/accounting"
 	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/events"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
 	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/webhook"
 )
 
 func authorize(next http.Handler, secret string) http.Handler {
@@ -45,19 +51,93 @@ func main() {
 		os.Exit(1)
 	}
 	updateLogLevel(conf.LogLevel)
+	hierarchy.LogMode()
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", metrics.MetricsHandler(conf.RootCGroup, conf.MetaMetrics))
 	mux.Handle("/", http.NotFoundHandler())
 
+	// endpoints other than /metrics require a bearer token unless running
+	// in insecure mode
+	secure := func(h http.Handler) http.Handler {
+		if conf.InsecureMode {
+			return h
+		}
+		return authorize(h, conf.BearerToken)
+	}
+
+	if conf.CollectInterval > 0 {
+		snapshotter := metrics.NewSnapshotter(conf.RootCGroup, conf.CollectInterval)
+		go snapshotter.Run(context.Background())
+		mux.Handle("/metrics", metrics.SnapshotHandler(snapshotter, conf.MetaMetrics))
+		mux.Handle("/status", secure(metrics.SnapshotStatusHandler(snapshotter)))
+	} else {
+		collector := metrics.NewCollector(conf.RootCGroup)
+		mux.Handle("/metrics", metrics.MetricsHandler(collector, conf.MetaMetrics))
+		mux.Handle("/status", secure(metrics.StatusHandler(collector)))
+	}
+
+	broker := events.NewBroker()
+	mux.Handle("/events", secure(events.StreamHandler(broker)))
+	mux.Handle("/control", secure(control.ControlHandler(conf.RootCGroup, broker)))
+
+	err = control.ResumeFrozen(conf.RootCGroup, broker)
+	if err != nil {
+		slog.Error("Unable to resume frozen units", "path", conf.FreezeStatePath, "err", err)
+		os.Exit(1)
+	}
+	go control.SweepFrozen(context.Background(), conf.RootCGroup)
+
+	watcher, err := events.NewWatcher(broker, conf.RootCGroup)
+	if err != nil {
+		slog.Warn("Unable to watch cgroups, memory and cgroup events will not be published", "err", err)
+	} else {
+		go watcher.Run(context.Background())
+	}
+
+	if conf.EventMemoryThreshold > 0 || conf.EventCPUThreshold > 0 {
+		metrics.RegisterObserver(events.NewThresholds(broker, conf.EventMemoryThreshold, conf.EventCPUThreshold))
+	}
+
+	if len(conf.WebhookURLs) > 0 {
+		notifier, err := webhook.NewNotifier(broker, conf.WebhookURLs, conf.WebhookQueuePath)
+		if err != nil {
+			slog.Error("Unable to start webhook notifier", "err", err)
+			os.Exit(1)
+		}
+		go notifier.Run(context.Background())
+	}
+
+	if conf.NotifyTerminals || conf.NotifyFile != "" {
+		notifier, err := notify.NewNotifier(broker, conf.NotifyTemplatePath)
+		if err != nil {
+			slog.Error("Unable to start user notifier", "err", err)
+			os.Exit(1)
+		}
+		go notifier.Run(context.Background())
+	}
+
+	if conf.HistorySize > 0 {
+		history := metrics.NewHistory()
+		metrics.RegisterObserver(history)
+		mux.Handle("/users/{name}/history", secure(metrics.HistoryHandler(history)))
+	}
+
+	if conf.AccountingPath != "" {
+		ledger, err := accounting.NewLedger(conf.AccountingPath, conf.AccountingBucket, conf.AccountingRetention)
+		if err != nil {
+			slog.Error("Unable to open accounting ledger", "path", conf.AccountingPath, "err", err)
+			os.Exit(1)
+		}
+		metrics.RegisterObserver(ledger)
+		mux.Handle("/accounting", secure(accounting.AccountingHandler(ledger)))
+	}
+
 	if conf.InsecureMode {
-		mux.Handle("/control", control.ControlHandler(conf.RootCGroup))
 		slog.Info("Starting server!")
 		slog.Error("server error", "err", http.ListenAndServe(conf.ListenAddress, mux))
 		os.Exit(1)
 
 	} else {
-		mux.Handle("/control", authorize(control.ControlHandler(conf.RootCGroup), conf.BearerToken))
 		slog.Info("Starting server")
 		slog.Error("server error", "err", http.ListenAndServeTLS(conf.ListenAddress, conf.Certificate, conf.PrivateKey, mux))
 		os.Exit(1)
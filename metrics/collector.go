@@ -1,53 +1,112 @@
 package metrics
 
 import (
+	"context"
 	"log/slog"
 	"math"
 	"net/http"
-	"sync"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	USPerS               = 1000000    // million
 	NSPerS               = 1000000000 // billion
 	MaxCGroupMemoryLimit = 9223372036854771712
-	cgroupRoot           = "/sys/fs/cgroup"
+)
+
+// stages of collection reported in cgroup_warden_collect_errors_total
+const (
+	stageGroups   = "groups"
+	stageCGroup   = "cgroup"
+	stageProcess  = "process"
+	stageDeadline = "deadline"
+	stageSessions = "sessions"
+)
+
+// LegacyMetricNames exports metrics under the names and types used before the
+// move to OpenMetrics conventions: counters without the _total suffix, limits
+// without a unit, and the CPU quota as a counter.
+var LegacyMetricNames = false
+
+// CollectTimeout bounds how long a single collection may take. Groups that
+// have not finished by then are left out of the results.
+var CollectTimeout = 10 * time.Second
+
+// GroupWorkers bounds the number of cgroups collected concurrently, and
+// ProcessWorkers the number of processes read concurrently within each
+// cgroup. A value of zero leaves the respective step unbounded.
+var (
+	GroupWorkers   = 8
+	ProcessWorkers = 1
 )
 
 var (
 	namespace  = "cgroup_warden"
 	labels     = []string{"cgroup", "username"}
 	procLabels = []string{"cgroup", "username", "proc"}
+	userLabels = []string{"cgroup", "username", "uid", "gid", "group", "groups"}
+
+	pressureLabels    = []string{"cgroup", "username", "resource", "kind"}
+	sessionLabels     = []string{"cgroup", "username", "session"}
+	sessionInfoLabels = []string{"cgroup", "username", "session", "service", "tty", "remote_host"}
 )
 
-func MetricsHandler(root string, meta bool) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		registry := prometheus.NewRegistry()
-		collector := NewCollector(root)
-		registry.MustRegister(collector)
-		gatherers := prometheus.Gatherers{registry}
-		if meta {
-			gatherers = append(gatherers, prometheus.DefaultGatherer)
-		}
-		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
-		h.ServeHTTP(w, r)
+// MetricsHandler runs c on each scrape.
+func MetricsHandler(c *Collector, meta bool) http.Handler {
+	return handlerFor(c, meta)
+}
+
+// SnapshotHandler serves the most recent snapshot taken by s.
+func SnapshotHandler(s *Snapshotter, meta bool) http.Handler {
+	return handlerFor(s, meta)
+}
+
+func handlerFor(collector prometheus.Collector, meta bool) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	gatherers := prometheus.Gatherers{registry}
+	if meta {
+		gatherers = append(gatherers, prometheus.DefaultGatherer)
 	}
+	return openMetricsHandler(gatherers)
 }
 
 type Collector struct {
-	root        string
-	memoryUsage *prometheus.Desc
-	cpuUsage    *prometheus.Desc
-	procCPU     *prometheus.Desc
-	procMemory  *prometheus.Desc
-	procPSS     *prometheus.Desc
-	procCount   *prometheus.Desc
-	memoryMax   *prometheus.Desc
-	cpuQuota    *prometheus.Desc
+	root            string
+	memoryUsage     *prometheus.Desc
+	cpuUsage        *prometheus.Desc
+	procCPU         *prometheus.Desc
+	procMemory      *prometheus.Desc
+	procPSS         *prometheus.Desc
+	procPSSAge      *prometheus.Desc
+	procSwap        *prometheus.Desc
+	procFolded      *prometheus.Desc
+	procRead        *prometheus.Desc
+	procWrite       *prometheus.Desc
+	procThreads     *prometheus.Desc
+	procFDs         *prometheus.Desc
+	procVoluntary   *prometheus.Desc
+	procInvoluntary *prometheus.Desc
+	procCount       *prometheus.Desc
+	memoryMax       *prometheus.Desc
+	swapUsage       *prometheus.Desc
+	swapMax         *prometheus.Desc
+	cpuQuota        *prometheus.Desc
+	frozen          *prometheus.Desc
+	pressure        *prometheus.Desc
+	userInfo        *prometheus.Desc
+	userSessions    *prometheus.Desc
+	sessionInfo     *prometheus.Desc
+	sessionStart    *prometheus.Desc
+	partial         *prometheus.Desc
+	quotaType       prometheus.ValueType
+
+	collectErrors *prometheus.CounterVec
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
@@ -57,64 +116,278 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.procMemory
 	ch <- c.procCount
 	ch <- c.procPSS
+	ch <- c.procPSSAge
+	ch <- c.procSwap
+	ch <- c.procFolded
+	ch <- c.procRead
+	ch <- c.procWrite
+	ch <- c.procVoluntary
+	ch <- c.procInvoluntary
+	ch <- c.procThreads
+	ch <- c.procFDs
 	ch <- c.memoryMax
+	ch <- c.swapUsage
+	ch <- c.swapMax
 	ch <- c.cpuQuota
+	ch <- c.frozen
+	ch <- c.pressure
+	ch <- c.userInfo
+	ch <- c.userSessions
+	ch <- c.sessionInfo
+	ch <- c.sessionStart
+	ch <- c.partial
+	c.collectErrors.Describe(ch)
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	_, err := c.collect(context.Background(), ch)
+	if err != nil {
+		slog.Error("could not collect cgroups with pids", "err", err)
+	}
+}
+
+// collection is the usage gathered by a single collection.
+type collection struct {
+	time    time.Time
+	usage   []GroupUsage
+	partial bool
+}
+
+// groupResult holds everything collected for a single cgroup. A failed stage
+// is recorded so the group can be counted towards a partial collection.
+type groupResult struct {
+	cgroup string
+	info   hierarchy.CGroupInfo
+	procs  map[string]ProcessAggregation
+	failed string
+	err    error
+}
+
+// collect sends metrics for every cgroup under the root to ch and returns the
+// usage they were derived from, or an error if the cgroups themselves could
+// not be enumerated. Groups that do not finish within CollectTimeout are left
+// out, and the collection is reported as partial.
+//
+// If ch is nil, the usage is only gathered: no metrics are sent, and neither
+// the error counters, the process selections and cache nor the observers are
+// updated, so that /status does not add samples of its own.
+func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) (collection, error) {
+	start := time.Now()
+	if CollectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CollectTimeout)
+		defer cancel()
+	}
+
+	observe := ch != nil
+	if observe {
+		defer c.collectErrors.Collect(ch)
+	}
+
 	h := hierarchy.NewHierarchy(c.root)
 
-	groups, err := h.GetGroupsWithPIDs()
+	groups, err := h.GetGroupsWithPIDs(ctx)
 	if err != nil {
-		slog.Error("could not collect cgroups with pids", "err", err)
-		return
+		if observe {
+			c.collectErrors.WithLabelValues(stageGroups).Inc()
+			ch <- prometheus.MustNewConstMetric(c.partial, prometheus.GaugeValue, 1)
+		}
+		return collection{}, err
+	}
+
+	var sessions map[string][]Session
+	if SessionsEnabled {
+		sessions, err = listSessions(ctx)
+		if err != nil {
+			slog.Warn("unable to list login sessions", "err", err)
+			c.countError(observe, stageSessions, 1)
+		}
 	}
 
-	wg := sync.WaitGroup{}
 	active := make(map[string]bool)
-	for cg, pids := range groups {
+	for cg := range groups {
 		active[cg] = true
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	}
+
+	results := fanOut(ctx, groups, func(cg string, pids map[uint64]bool) groupResult {
+		return collectGroup(ctx, h, cg, pids)
+	})
 
-			info, err := h.CGroupInfo(cg)
-			if err != nil {
-				slog.Warn("unable to collect group info", "cgroup", cg, "err", err)
-				return
+	var usage []GroupUsage
+	partial := false
+collection:
+	for remaining := len(groups); remaining > 0; remaining-- {
+		select {
+		case result := <-results:
+			if result.failed != "" {
+				slog.Warn("unable to collect "+result.failed+" info", "cgroup", result.cgroup, "err", result.err)
+				c.countError(observe, result.failed, 1)
+				partial = true
+				if result.failed == stageCGroup {
+					continue
+				}
 			}
+			if observe {
+				c.emit(ch, result)
+				if SessionsEnabled {
+					c.emitSessions(ch, result, sessions[result.info.UID])
+				}
+			}
+			usage = append(usage, GroupUsage{
+				CGroup:    result.cgroup,
+				Time:      start,
+				Info:      result.info,
+				Processes: result.procs,
+			})
+		case <-ctx.Done():
+			slog.Warn("collection deadline exceeded, reporting partial results", "missing", remaining, "err", ctx.Err())
+			c.countError(observe, stageDeadline, remaining)
+			partial = true
+			break collection
+		}
+	}
 
-			ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, float64(info.MemoryUsage), cg, info.Username)
-			ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.CounterValue, info.CPUUsage, cg, info.Username)
-			ch <- prometheus.MustNewConstMetric(c.memoryMax, prometheus.GaugeValue, negativeOneIfMax(info.MemoryMax), cg, info.Username)
-			ch <- prometheus.MustNewConstMetric(c.cpuQuota, prometheus.CounterValue, float64(info.CPUQuota), cg, info.Username)
+	if observe {
+		ch <- prometheus.MustNewConstMetric(c.partial, prometheus.GaugeValue, boolToFloat(partial))
+		CleanProcessCache(active)
+		notify(usage)
+	}
+	return collection{time: start, usage: usage, partial: partial}, nil
+}
 
-			procs, err := ProcessInfo(cg, pids)
-			if err != nil {
-				slog.Warn("unable to collect process info", "cgroup", cg, "err", err)
-				return
-			}
+// countError adds n errors at stage to cgroup_warden_collect_errors_total,
+// unless the collection is not observed.
+func (c *Collector) countError(observe bool, stage string, n int) {
+	if observe {
+		c.collectErrors.WithLabelValues(stage).Add(float64(n))
+	}
+}
 
-			for name, p := range procs {
-				ch <- prometheus.MustNewConstMetric(c.procCPU, prometheus.CounterValue, float64(p.cpuSecondsTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procMemory, prometheus.GaugeValue, float64(p.memoryBytesTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procPSS, prometheus.GaugeValue, float64(p.memoryPSSTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procCount, prometheus.GaugeValue, float64(p.count), cg, info.Username, name)
+// status gathers the usage of every cgroup without sending any metrics or
+// notifying the observers.
+func (c *Collector) status(ctx context.Context) (collection, error) {
+	return c.collect(ctx, nil)
+}
+
+// fanOut runs collect for every group on up to GroupWorkers goroutines, and
+// returns the channel their results are sent to. It is buffered so that
+// groups finishing after ctx is done do not block, and groups not yet
+// started by then are skipped.
+func fanOut(ctx context.Context, groups map[string]map[uint64]bool, collect func(cg string, pids map[uint64]bool) groupResult) <-chan groupResult {
+	jobs := make(chan string, len(groups))
+	for cg := range groups {
+		jobs <- cg
+	}
+	close(jobs)
+
+	workers := GroupWorkers
+	if workers <= 0 || workers > len(groups) {
+		workers = len(groups)
+	}
+
+	results := make(chan groupResult, len(groups))
+	for range workers {
+		go func() {
+			for cg := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				results <- collect(cg, groups[cg])
 			}
 		}()
 	}
-	wg.Wait()
-	CleanProcessCache(active)
+	return results
+}
+
+func collectGroup(ctx context.Context, h hierarchy.Hierarchy, cg string, pids map[uint64]bool) groupResult {
+	result := groupResult{cgroup: cg}
+
+	result.info, result.err = h.CGroupInfo(ctx, cg)
+	if result.err != nil {
+		result.failed = stageCGroup
+		return result
+	}
+
+	result.procs, result.err = ProcessInfo(ctx, cg, pids)
+	if result.err != nil {
+		result.failed = stageProcess
+	}
+	return result
+}
+
+func (c *Collector) emit(ch chan<- prometheus.Metric, result groupResult) {
+	cg, info := result.cgroup, result.info
+	procs, folded := limitProcesses(result.procs, selectProcesses(cg, result.procs))
+
+	ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, float64(info.MemoryUsage), cg, info.Username)
+	ch <- counter(c.cpuUsage, info.CPUUsage, info.Created, cg, info.Username)
+	ch <- prometheus.MustNewConstMetric(c.memoryMax, prometheus.GaugeValue, negativeOneIfMax(info.MemoryMax), cg, info.Username)
+	ch <- prometheus.MustNewConstMetric(c.swapUsage, prometheus.GaugeValue, float64(info.SwapUsage), cg, info.Username)
+	ch <- prometheus.MustNewConstMetric(c.swapMax, prometheus.GaugeValue, negativeOneIfMax(info.SwapMax), cg, info.Username)
+	ch <- prometheus.MustNewConstMetric(c.cpuQuota, c.quotaType, float64(info.CPUQuota), cg, info.Username)
+	ch <- prometheus.MustNewConstMetric(c.frozen, prometheus.GaugeValue, boolToFloat(info.Frozen), cg, info.Username)
+
+	for resource, pressure := range info.Pressure {
+		for kind, seconds := range pressure {
+			ch <- counter(c.pressure, seconds, info.Created, cg, info.Username, resource, kind)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.userInfo, prometheus.GaugeValue, 1, cg, info.Username, info.UID, info.GID, info.Group, strings.Join(info.Groups, ","))
+
+	if ProcessLimit > 0 {
+		ch <- prometheus.MustNewConstMetric(c.procFolded, prometheus.GaugeValue, float64(folded), cg, info.Username)
+	}
+
+	// per-process counters have no created time, as they reset on their own
+	// when a name expires after ProcessRetention
+	for name, p := range procs {
+		ch <- prometheus.MustNewConstMetric(c.procCPU, prometheus.CounterValue, p.CPUSeconds, cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procMemory, prometheus.GaugeValue, float64(p.MemoryBytes), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procCount, prometheus.GaugeValue, float64(p.Count), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procSwap, prometheus.GaugeValue, float64(p.MemorySwap), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procRead, prometheus.CounterValue, float64(p.ReadBytes), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procWrite, prometheus.CounterValue, float64(p.WriteBytes), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procVoluntary, prometheus.CounterValue, float64(p.VoluntaryContextSwitches), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procInvoluntary, prometheus.CounterValue, float64(p.InvoluntaryContextSwitches), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procThreads, prometheus.GaugeValue, float64(p.Threads), cg, info.Username, name)
+		ch <- prometheus.MustNewConstMetric(c.procFDs, prometheus.GaugeValue, float64(p.OpenFDs), cg, info.Username, name)
+		if PSSEnabled {
+			ch <- prometheus.MustNewConstMetric(c.procPSS, prometheus.GaugeValue, float64(p.MemoryPSS), cg, info.Username, name)
+			ch <- prometheus.MustNewConstMetric(c.procPSSAge, prometheus.GaugeValue, p.MemoryPSSAge, cg, info.Username, name)
+		}
+	}
+}
+
+// emitSessions sends the login sessions of the user owning result, labelled
+// with the scope they run in.
+func (c *Collector) emitSessions(ch chan<- prometheus.Metric, result groupResult, sessions []Session) {
+	cg, info := result.cgroup, result.info
+
+	ch <- prometheus.MustNewConstMetric(c.userSessions, prometheus.GaugeValue, float64(len(sessions)), cg, info.Username)
+
+	for _, s := range sessions {
+		scope := path.Join(cg, s.Scope)
+		ch <- prometheus.MustNewConstMetric(c.sessionInfo, prometheus.GaugeValue, 1, scope, info.Username, s.ID, s.Service, s.TTY, s.RemoteHost)
+		if !s.Start.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.sessionStart, prometheus.GaugeValue, float64(s.Start.UnixNano())/NSPerS, scope, info.Username, s.ID)
+		}
+	}
 }
 
 func NewCollector(root string) *Collector {
+	quotaType := prometheus.GaugeValue
+	if LegacyMetricNames {
+		quotaType = prometheus.CounterValue
+	}
+
 	return &Collector{
-		root: root,
+		root:      root,
+		quotaType: quotaType,
 		memoryUsage: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "usage_bytes"),
 			"Total memory usage in bytes", labels, nil),
-		cpuUsage: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cpu", "usage_seconds"),
+		cpuUsage: prometheus.NewDesc(counterName("cpu", "usage_seconds"),
 			"Total CPU usage in seconds", labels, nil),
-		procCPU: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "cpu_usage_seconds"),
+		procCPU: prometheus.NewDesc(counterName("proc", "cpu_usage_seconds"),
 			"Aggregate CPU usage for this process in seconds", procLabels, nil),
 		procMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_usage_bytes"),
 			"Aggregate memory usage for this process", procLabels, nil),
@@ -122,11 +395,84 @@ func NewCollector(root string) *Collector {
 			"Instance count of this process", procLabels, nil),
 		procPSS: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_pss_bytes"),
 			"Aggregate PSS memory usage of this process", procLabels, nil),
-		memoryMax: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "max"),
+		procPSSAge: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_pss_age_seconds"),
+			"Age of the oldest PSS value aggregated for this process in seconds", procLabels, nil),
+		procSwap: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_swap_bytes"),
+			"Aggregate swap usage of this process in bytes", procLabels, nil),
+		procFolded: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "folded"),
+			"Number of process names aggregated into proc=\"other\" for this unit", labels, nil),
+		procRead: prometheus.NewDesc(counterName("proc", "io_read_bytes"),
+			"Aggregate bytes read from storage by this process", procLabels, nil),
+		procWrite: prometheus.NewDesc(counterName("proc", "io_write_bytes"),
+			"Aggregate bytes written to storage by this process", procLabels, nil),
+		procVoluntary: prometheus.NewDesc(counterName("proc", "voluntary_context_switches"),
+			"Aggregate voluntary context switches of this process", procLabels, nil),
+		procInvoluntary: prometheus.NewDesc(counterName("proc", "involuntary_context_switches"),
+			"Aggregate involuntary context switches of this process", procLabels, nil),
+		procThreads: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "threads"),
+			"Thread count of this process", procLabels, nil),
+		procFDs: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "open_fds"),
+			"Open file descriptor count of this process", procLabels, nil),
+		memoryMax: prometheus.NewDesc(compatName("memory", "max_bytes", "max"),
 			"Maximum memory limit of this unit in bytes.", labels, nil),
+		swapUsage: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "swap_usage_bytes"),
+			"Total swap usage in bytes", labels, nil),
+		swapMax: prometheus.NewDesc(compatName("memory", "swap_max_bytes", "swap_max"),
+			"Maximum swap limit of this unit in bytes.", labels, nil),
 		cpuQuota: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cpu", "quota"),
-			"Maximum CPU quota of this unit in micro seconds per second", labels, nil),
+			"Maximum CPU quota of this unit in micro seconds per second, or -1 if unlimited", labels, nil),
+		frozen: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "frozen"),
+			"Whether the processes of this unit are frozen", labels, nil),
+		pressure: prometheus.NewDesc(counterName("pressure", "stalled_seconds"),
+			"Total time some or all tasks of this unit were stalled on a resource in seconds", pressureLabels, nil),
+		userInfo: prometheus.NewDesc(prometheus.BuildFQName(namespace, "user", "info"),
+			"Identity of the user owning this unit, with allowlisted supplementary groups", userLabels, nil),
+		userSessions: prometheus.NewDesc(prometheus.BuildFQName(namespace, "user", "sessions"),
+			"Number of logind login sessions of the user owning this unit", labels, nil),
+		sessionInfo: prometheus.NewDesc(prometheus.BuildFQName(namespace, "session", "info"),
+			"Login session running in this scope, with its service, terminal and remote host", sessionInfoLabels, nil),
+		sessionStart: prometheus.NewDesc(prometheus.BuildFQName(namespace, "session", "start_time_seconds"),
+			"Start time of the login session running in this scope since the epoch in seconds", sessionLabels, nil),
+		partial: prometheus.NewDesc(prometheus.BuildFQName(namespace, "collect", "partial"),
+			"Whether the most recent collection left out any cgroups", nil, nil),
+		collectErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "collect",
+			Name:      "errors_total",
+			Help:      "Number of errors encountered during collection by stage",
+		}, []string{"stage"}),
+	}
+}
+
+// counterName is the name of a counter, which carries the _total suffix unless
+// LegacyMetricNames is set.
+func counterName(subsystem, name string) string {
+	return compatName(subsystem, name+"_total", name)
+}
+
+// compatName is the name of a metric that was renamed, which is legacy if
+// LegacyMetricNames is set.
+func compatName(subsystem, name, legacy string) string {
+	if LegacyMetricNames {
+		return prometheus.BuildFQName(namespace, subsystem, legacy)
+	}
+	return prometheus.BuildFQName(namespace, subsystem, name)
+}
+
+// counter creates a counter created at the given time, which is omitted if
+// unknown.
+func counter(desc *prometheus.Desc, value float64, created time.Time, labelValues ...string) prometheus.Metric {
+	if created.IsZero() {
+		return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labelValues...)
+	}
+	return prometheus.MustNewConstMetricWithCreatedTimestamp(desc, prometheus.CounterValue, value, created, labelValues...)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
 
 // max memory value is a maxint64 rounded down to the nearest page number
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// writeProc writes the files read for pid under the synthetic /proc at root.
+func writeProc(tb testing.TB, root string, pid uint64, comm string) {
+	tb.Helper()
+
+	dir := path.Join(root, fmt.Sprint(pid))
+	fields := make([]string, 49)
+	for i := range fields {
+		fields[i] = "0"
+	}
+	fields[0] = "S"
+	fields[11] = "150"                  // utime
+	fields[12] = "50"                   // stime
+	fields[17] = "4"                    // num_threads
+	fields[19] = fmt.Sprint(1000 + pid) // starttime
+	fields[21] = "2048"                 // rss
+
+	status := "Name:\t" + comm + "\nVmSwap:\t128 kB\nvoluntary_ctxt_switches:\t10\nnonvoluntary_ctxt_switches:\t2\n"
+	files := map[string]string{
+		"stat":         fmt.Sprintf("%d (%s) %s\n", pid, comm, strings.Join(fields, " ")),
+		"comm":         comm + "\n",
+		"cmdline":      "/usr/bin/" + comm + "\x00--flag\x00",
+		"io":           "rchar: 0\nwchar: 0\nsyscr: 0\nsyscw: 0\nread_bytes: 4096\nwrite_bytes: 8192\ncancelled_write_bytes: 0\n",
+		"status":       status,
+		"smaps_rollup": "00400000-7fff00000000 ---p 00000000 00:00 0 [rollup]\nRss: 8192 kB\nPss: 4096 kB\n",
+	}
+
+	for _, d := range []string{"fd", path.Join("task", fmt.Sprint(pid))} {
+		if err := os.MkdirAll(path.Join(dir, d), 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := range 8 {
+		if err := os.WriteFile(path.Join(dir, "fd", fmt.Sprint(i)), nil, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	files[path.Join("task", fmt.Sprint(pid), "status")] = status
+
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// procFixture builds a synthetic /proc with perGroup processes in each of
+// groups cgroups, and points ProcPath at it.
+func procFixture(b *testing.B, groups, perGroup int) map[string]map[uint64]bool {
+	b.Helper()
+
+	root := b.TempDir()
+	saved := hierarchy.ProcPath
+	hierarchy.ProcPath = root
+	b.Cleanup(func() { hierarchy.ProcPath = saved })
+
+	commands := []string{"bash", "python3", "matlab", "sshd", "vim"}
+	result := make(map[string]map[uint64]bool, groups)
+	pid := uint64(100)
+	for g := range groups {
+		pids := make(map[uint64]bool, perGroup)
+		for i := range perGroup {
+			writeProc(b, root, pid, commands[i%len(commands)])
+			pids[pid] = true
+			pid++
+		}
+		result[fmt.Sprintf("/user.slice/user-%d.slice", 1000+g)] = pids
+	}
+	return result
+}
+
+func TestProcessInfoFixture(t *testing.T) {
+	root := t.TempDir()
+	saved := hierarchy.ProcPath
+	hierarchy.ProcPath = root
+	defer func() { hierarchy.ProcPath = saved }()
+
+	writeProc(t, root, 100, "python3")
+	writeProc(t, root, 101, "python3")
+
+	results, err := ProcessInfo(context.Background(), "/fixture.slice", map[uint64]bool{100: true, 101: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := results["python3"]
+	if got.Count != 2 || got.CPUSeconds != 4 || got.ReadBytes != 8192 || got.OpenFDs != 16 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+// BenchmarkProcessInfo measures reading the processes of one cgroup with
+// different numbers of process workers.
+func BenchmarkProcessInfo(b *testing.B) {
+	defer func(workers int) { ProcessWorkers = workers }(ProcessWorkers)
+
+	groups := procFixture(b, 1, 200)
+	for _, workers := range []int{1, 4, 16, 0} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			ProcessWorkers = workers
+			for range b.N {
+				for cg, pids := range groups {
+					if _, err := ProcessInfo(context.Background(), cg, pids); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCollectGroups measures reading the processes of many cgroups,
+// fanned out over group workers by the same pool as collect.
+func BenchmarkCollectGroups(b *testing.B) {
+	defer func(group, process int) {
+		GroupWorkers, ProcessWorkers = group, process
+	}(GroupWorkers, ProcessWorkers)
+
+	groups := procFixture(b, 50, 20)
+	for _, workers := range [][2]int{{1, 1}, {8, 1}, {8, 4}, {0, 0}} {
+		b.Run(fmt.Sprintf("groups=%d/processes=%d", workers[0], workers[1]), func(b *testing.B) {
+			GroupWorkers, ProcessWorkers = workers[0], workers[1]
+			for range b.N {
+				ctx := context.Background()
+				results := fanOut(ctx, groups, func(cg string, pids map[uint64]bool) groupResult {
+					result := groupResult{cgroup: cg}
+					result.procs, result.err = ProcessInfo(ctx, cg, pids)
+					return result
+				})
+				for range groups {
+					if result := <-results; result.err != nil {
+						b.Fatal(result.err)
+					}
+				}
+			}
+		})
+	}
+}
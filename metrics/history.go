@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HistorySize is the number of samples kept per cgroup. It must be positive
+// for a History to be used.
+var HistorySize = 60
+
+// cgroups not observed for this long are dropped from the history
+const historyStale = time.Hour
+
+// Sample is the usage of a cgroup as of one collection.
+type Sample struct {
+	Time        time.Time `json:"time"`
+	CPUSeconds  float64   `json:"cpu_seconds"`
+	MemoryBytes uint64    `json:"memory_bytes"`
+	ReadBytes   uint64    `json:"read_bytes"`
+	WriteBytes  uint64    `json:"write_bytes"`
+}
+
+// Rate is the usage of a cgroup between two consecutive samples, ending at
+// Time.
+type Rate struct {
+	Time            time.Time `json:"time"`
+	CPUCores        float64   `json:"cpu_cores"`
+	ReadMBPerSec    float64   `json:"read_mb_per_second"`
+	WriteMBPerSec   float64   `json:"write_mb_per_second"`
+	MemoryBytes     uint64    `json:"memory_bytes"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+}
+
+// ring is a fixed size buffer of the most recent samples of a cgroup.
+type ring struct {
+	username string
+	samples  []Sample
+	next     int
+}
+
+func (r *ring) add(s Sample) {
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// ordered returns the samples from oldest to newest.
+func (r *ring) ordered() []Sample {
+	return slices.Concat(r.samples[r.next:], r.samples[:r.next])
+}
+
+func (r *ring) latest() Sample {
+	if r.next == 0 {
+		return r.samples[len(r.samples)-1]
+	}
+	return r.samples[r.next-1]
+}
+
+// History keeps the HistorySize most recent samples of every cgroup.
+type History struct {
+	groups map[string]*ring
+	mutex  sync.Mutex
+}
+
+func NewHistory() *History {
+	return &History{
+		groups: make(map[string]*ring),
+		mutex:  sync.Mutex{},
+	}
+}
+
+func (h *History) Observe(usage []GroupUsage) {
+	defer h.mutex.Unlock()
+	h.mutex.Lock()
+
+	for _, u := range usage {
+		sample := Sample{
+			Time:        u.Time,
+			CPUSeconds:  u.Info.CPUUsage,
+			MemoryBytes: u.Info.MemoryUsage,
+		}
+		for _, p := range u.Processes {
+			sample.ReadBytes += p.ReadBytes
+			sample.WriteBytes += p.WriteBytes
+		}
+
+		r, ok := h.groups[u.CGroup]
+		if !ok {
+			r = &ring{samples: make([]Sample, 0, HistorySize)}
+			h.groups[u.CGroup] = r
+		}
+		r.username = u.Info.Username
+		r.add(sample)
+	}
+
+	now := time.Now()
+	for cg, r := range h.groups {
+		if now.Sub(r.latest().Time) > historyStale {
+			delete(h.groups, cg)
+		}
+	}
+}
+
+type groupHistory struct {
+	CGroup  string   `json:"cgroup"`
+	Samples []Sample `json:"samples"`
+	Rates   []Rate   `json:"rates"`
+}
+
+type historyResponse struct {
+	Username string         `json:"username"`
+	CGroups  []groupHistory `json:"cgroups"`
+}
+
+// user returns the history of every cgroup owned by username since the
+// given time.
+func (h *History) user(username string, since time.Time) []groupHistory {
+	defer h.mutex.Unlock()
+	h.mutex.Lock()
+
+	var groups []groupHistory
+	for cg, r := range h.groups {
+		if r.username != username {
+			continue
+		}
+
+		samples := slices.DeleteFunc(r.ordered(), func(s Sample) bool {
+			return s.Time.Before(since)
+		})
+		groups = append(groups, groupHistory{
+			CGroup:  cg,
+			Samples: samples,
+			Rates:   rates(samples),
+		})
+	}
+
+	slices.SortFunc(groups, func(a, b groupHistory) int {
+		return cmp.Compare(a.CGroup, b.CGroup)
+	})
+	return groups
+}
+
+// rates derives the usage rates between consecutive samples. Intervals over
+// which a counter decreased, as happens when a cgroup is recreated, are
+// skipped.
+func rates(samples []Sample) []Rate {
+	rates := make([]Rate, 0, max(len(samples)-1, 0))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		seconds := cur.Time.Sub(prev.Time).Seconds()
+		if seconds <= 0 || cur.CPUSeconds < prev.CPUSeconds || cur.ReadBytes < prev.ReadBytes || cur.WriteBytes < prev.WriteBytes {
+			continue
+		}
+
+		rates = append(rates, Rate{
+			Time:            cur.Time,
+			CPUCores:        (cur.CPUSeconds - prev.CPUSeconds) / seconds,
+			ReadMBPerSec:    float64(cur.ReadBytes-prev.ReadBytes) / seconds / 1e6,
+			WriteMBPerSec:   float64(cur.WriteBytes-prev.WriteBytes) / seconds / 1e6,
+			MemoryBytes:     cur.MemoryBytes,
+			IntervalSeconds: seconds,
+		})
+	}
+	return rates
+}
+
+// HistoryHandler serves the recent usage of the user given by the name path
+// value. The window query parameter, a duration like 10m, limits the samples
+// returned to those taken within it.
+func HistoryHandler(h *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("name")
+
+		var since time.Time
+		if value := r.URL.Query().Get("window"); value != "" {
+			window, err := time.ParseDuration(value)
+			if err != nil || window <= 0 {
+				http.Error(w, "invalid window, expected a positive duration like 10m", http.StatusBadRequest)
+				return
+			}
+			since = time.Now().Add(-window)
+		}
+
+		groups := h.user(username, since)
+		if len(groups) == 0 {
+			http.Error(w, "no history for user "+username, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(historyResponse{Username: username, CGroups: groups})
+	}
+}
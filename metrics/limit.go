@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// orderings used to pick which processes are kept under ProcessLimit
+const (
+	LimitByCPU    = "cpu"
+	LimitByMemory = "memory"
+)
+
+// OtherProcess is the proc label processes are folded into once a group
+// exceeds ProcessLimit distinct process names.
+const OtherProcess = "other"
+
+// ProcessLimit bounds the number of distinct process names exported per
+// group. The first ProcessLimit names, in order of LimitBy among the names
+// that appeared at once, are kept, and the remainder is aggregated under
+// OtherProcess. A limit of zero or less disables folding.
+var (
+	ProcessLimit = 0
+	LimitBy      = LimitByCPU
+)
+
+// selection is the split of the process names of a group into those
+// exported under their own name and those folded into OtherProcess. It is
+// sticky, so that the counters of OtherProcess do not jump up and down as
+// names move in and out of it. A name keeps its place while it is alive or
+// retained, and new names fill the free places in order of LimitBy.
+type selection struct {
+	kept   map[string]bool
+	folded map[string]bool
+}
+
+var selections = struct {
+	data  map[string]*selection
+	mutex sync.Mutex
+}{data: make(map[string]*selection)}
+
+// next returns the selection for procs that follows from s, without
+// modifying s. Names that are no longer present lose their place, and names
+// s has not seen fill the free places in order of LimitBy.
+func (s selection) next(procs map[string]ProcessAggregation) selection {
+	n := selection{kept: make(map[string]bool), folded: make(map[string]bool)}
+
+	var names []string
+	for name := range procs {
+		switch {
+		case s.kept[name]:
+			n.kept[name] = true
+		case s.folded[name]:
+			n.folded[name] = true
+		default:
+			names = append(names, name)
+		}
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		pa, pb := procs[a], procs[b]
+		var order int
+		if LimitBy == LimitByMemory {
+			order = cmp.Compare(pb.MemoryBytes, pa.MemoryBytes)
+		} else {
+			order = cmp.Compare(pb.CPUSeconds, pa.CPUSeconds)
+		}
+		if order == 0 {
+			order = cmp.Compare(a, b)
+		}
+		return order
+	})
+
+	for _, name := range names {
+		if name != OtherProcess && len(n.kept) < ProcessLimit {
+			n.kept[name] = true
+		} else {
+			n.folded[name] = true
+		}
+	}
+	return n
+}
+
+// selectProcesses advances the selection of cg to procs and returns it. It
+// is only called by collections, so that serving an older snapshot does not
+// move names in or out of OtherProcess.
+func selectProcesses(cg string, procs map[string]ProcessAggregation) selection {
+	if ProcessLimit <= 0 {
+		return selection{}
+	}
+
+	defer selections.mutex.Unlock()
+	selections.mutex.Lock()
+
+	s := selections.data[cg]
+	if s == nil {
+		s = &selection{}
+	}
+	*s = s.next(procs)
+	selections.data[cg] = s
+	return *s
+}
+
+// currentSelection returns the selection of cg as of the last collection.
+func currentSelection(cg string) selection {
+	defer selections.mutex.Unlock()
+	selections.mutex.Lock()
+
+	if s := selections.data[cg]; s != nil {
+		return *s
+	}
+	return selection{}
+}
+
+// limitProcesses folds the processes that do not fit within ProcessLimit
+// under the selection s into OtherProcess, returning the resulting
+// aggregations and the number of names that were folded. It does not modify
+// s.
+func limitProcesses(procs map[string]ProcessAggregation, s selection) (map[string]ProcessAggregation, int) {
+	if ProcessLimit <= 0 {
+		return procs, 0
+	}
+
+	s = s.next(procs)
+	if len(s.folded) == 0 {
+		return procs, 0
+	}
+
+	limited := make(map[string]ProcessAggregation, len(s.kept)+1)
+	var other ProcessAggregation
+	for name, p := range procs {
+		if s.kept[name] {
+			limited[name] = p
+		} else {
+			other.add(p)
+		}
+	}
+	limited[OtherProcess] = other
+
+	return limited, len(s.folded)
+}
+
+// cleanSelections forgets the selections of groups that are not active.
+func cleanSelections(active map[string]bool) {
+	defer selections.mutex.Unlock()
+	selections.mutex.Lock()
+	for cg := range selections.data {
+		if !active[cg] {
+			delete(selections.data, cg)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import "testing"
+
+func TestLimitProcessesSticky(t *testing.T) {
+	defer func(limit int, by string) { ProcessLimit, LimitBy = limit, by }(ProcessLimit, LimitBy)
+	ProcessLimit, LimitBy = 2, LimitByCPU
+	defer cleanSelections(nil)
+
+	cg := "/user.slice/user-1000.slice"
+	procs := map[string]ProcessAggregation{
+		"python3": {CPUSeconds: 30},
+		"bash":    {CPUSeconds: 20},
+		"vim":     {CPUSeconds: 10},
+	}
+
+	limited, folded := limitProcesses(procs, selectProcesses(cg, procs))
+	if folded != 1 || limited[OtherProcess].CPUSeconds != 10 {
+		t.Fatalf("got folded %d other %v, want 1 and 10", folded, limited[OtherProcess].CPUSeconds)
+	}
+
+	// vim overtakes bash, but the selection does not move, so the counters
+	// under other do not drop
+	procs["vim"] = ProcessAggregation{CPUSeconds: 50}
+	limited, _ = limitProcesses(procs, selectProcesses(cg, procs))
+	if _, ok := limited["bash"]; !ok {
+		t.Errorf("bash lost its place to vim")
+	}
+	if got := limited[OtherProcess].CPUSeconds; got != 50 {
+		t.Errorf("got other %v, want 50", got)
+	}
+
+	// bash expires, freeing its place for the next new name, while vim
+	// stays folded
+	delete(procs, "bash")
+	procs["make"] = ProcessAggregation{CPUSeconds: 1}
+	limited, folded = limitProcesses(procs, selectProcesses(cg, procs))
+	if _, ok := limited["make"]; !ok {
+		t.Errorf("make did not take the free place")
+	}
+	if folded != 1 || limited[OtherProcess].CPUSeconds != 50 {
+		t.Errorf("got folded %d other %v, want 1 and 50", folded, limited[OtherProcess].CPUSeconds)
+	}
+}
+
+func TestLimitProcessesUnderLimit(t *testing.T) {
+	defer func(limit int) { ProcessLimit = limit }(ProcessLimit)
+	ProcessLimit = 5
+	defer cleanSelections(nil)
+
+	procs := map[string]ProcessAggregation{"bash": {CPUSeconds: 1}}
+	cg := "/user.slice/user-1001.slice"
+	limited, folded := limitProcesses(procs, selectProcesses(cg, procs))
+	if folded != 0 || len(limited) != 1 {
+		t.Errorf("got %d names with %d folded, want 1 and 0", len(limited), folded)
+	}
+	if _, ok := limited[OtherProcess]; ok {
+		t.Errorf("other exported without folded names")
+	}
+}
+
+func TestLimitProcessesStatusKeepsSelection(t *testing.T) {
+	defer func(limit int, by string) { ProcessLimit, LimitBy = limit, by }(ProcessLimit, LimitBy)
+	ProcessLimit, LimitBy = 1, LimitByCPU
+	defer cleanSelections(nil)
+
+	cg := "/user.slice/user-1002.slice"
+	current := map[string]ProcessAggregation{
+		"bash": {CPUSeconds: 20},
+		"vim":  {CPUSeconds: 10},
+	}
+	limitProcesses(current, selectProcesses(cg, current))
+
+	// an older snapshot without bash, rendered by /status, would give its
+	// place to vim if it moved the selection
+	older := map[string]ProcessAggregation{"vim": {CPUSeconds: 5}}
+	limited, _ := limitProcesses(older, currentSelection(cg))
+	if _, ok := limited["vim"]; ok {
+		t.Errorf("vim took the place of bash in the status of an older snapshot")
+	}
+
+	limited, folded := limitProcesses(current, selectProcesses(cg, current))
+	if _, ok := limited["bash"]; !ok || folded != 1 {
+		t.Errorf("selection moved after rendering an older snapshot: got %v with %d folded", limited, folded)
+	}
+}
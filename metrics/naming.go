@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// sources a process name can be taken from
+const (
+	NameSourceComm = "comm"
+	NameSourceExe  = "exe"
+)
+
+// Naming rules applied to each process before aggregation. Patterns are
+// matched against the space separated command line in order, and the first
+// match names the process after its first capture group, or the whole match
+// if the pattern has none. Otherwise, the process is named by NameSource, and
+// if that name matches one of the Interpreters globs, by the basename of the
+// script being interpreted.
+var (
+	NameSource   = NameSourceComm
+	NamePatterns []*regexp.Regexp
+	Interpreters []string
+)
+
+// ProcessName determines the name proc is reported under in the proc label.
+func ProcessName(proc procfs.Proc) (string, error) {
+	comm, err := proc.Comm()
+	if err != nil {
+		return "", err
+	}
+
+	// the command line is unavailable for kernel threads and zombies
+	cmdline, _ := proc.CmdLine()
+
+	if len(NamePatterns) > 0 && len(cmdline) > 0 {
+		joined := strings.Join(cmdline, " ")
+		for _, re := range NamePatterns {
+			match := re.FindStringSubmatch(joined)
+			if match == nil {
+				continue
+			}
+			if len(match) > 1 && match[1] != "" {
+				return match[1], nil
+			}
+			return match[0], nil
+		}
+	}
+
+	name := comm
+	if NameSource == NameSourceExe {
+		if exe, err := proc.Executable(); err == nil && exe != "" {
+			name = path.Base(exe)
+		}
+	}
+
+	if isInterpreter(name) {
+		if script := scriptName(name, cmdline); script != "" {
+			return script, nil
+		}
+	}
+
+	return name, nil
+}
+
+func isInterpreter(name string) bool {
+	for _, pattern := range Interpreters {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// options of interpreters, by the prefix of their name, that take a value
+// in the next argument, and that run inline code instead of a script
+var (
+	valueOptions = map[string][]string{
+		"python": {"-W", "-X", "-Q", "--check-hash-based-pycs"},
+		"perl":   {"-I"},
+		"ruby":   {"-I", "-r", "-C", "-E"},
+		"node":   {"-r", "--require", "--import", "--loader"},
+		"bash":   {"-o", "+o", "-O", "+O"},
+		"sh":     {"-o", "+o"},
+	}
+	inlineOptions = map[string][]string{
+		"perl": {"-E"},
+		"node": {"-p", "--eval", "--print"},
+	}
+)
+
+// interpreterOptions returns the options of interpreter in options.
+func interpreterOptions(options map[string][]string, interpreter string) []string {
+	for prefix, opts := range options {
+		if strings.HasPrefix(interpreter, prefix) {
+			return opts
+		}
+	}
+	return nil
+}
+
+// scriptName finds the script interpreter was invoked with, which is the
+// first argument that is not an option or the value of one, or the argument
+// after --. Modules run with -m are named after the module. Returns the
+// empty string for interactive and inline (-c, -e) invocations.
+func scriptName(interpreter string, cmdline []string) string {
+	values := interpreterOptions(valueOptions, interpreter)
+	inline := append([]string{"-c", "-e"}, interpreterOptions(inlineOptions, interpreter)...)
+
+	for i := 1; i < len(cmdline); i++ {
+		arg := cmdline[i]
+		switch {
+		case arg == "-m" && i+1 < len(cmdline):
+			return cmdline[i+1]
+		case arg == "--":
+			if i+1 < len(cmdline) {
+				return path.Base(cmdline[i+1])
+			}
+			return ""
+		case slices.Contains(inline, arg):
+			return ""
+		case slices.Contains(values, arg):
+			i++
+		case strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+"):
+			continue
+		default:
+			return path.Base(arg)
+		}
+	}
+	return ""
+}
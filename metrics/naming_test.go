@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptName(t *testing.T) {
+	tests := []struct {
+		interpreter string
+		cmdline     string
+		want        string
+	}{
+		{"python3", "python3 /home/u/train.py --epochs 3", "train.py"},
+		{"python3", "python3 -u train.py", "train.py"},
+		{"python3", "python3 -W ignore train.py", "train.py"},
+		{"python3", "python3 -X dev -W error::DeprecationWarning train.py", "train.py"},
+		{"python2.7", "python2.7 -Q new train.py", "train.py"},
+		{"python3", "python3 -m http.server 8000", "http.server"},
+		{"python3", "python3 -c print(1)", ""},
+		{"python3", "python3", ""},
+		{"python3", "python3 -- -odd-name.py", "-odd-name.py"},
+		{"python3", "python3 --", ""},
+		{"perl", "perl -I /opt/lib -w report.pl", "report.pl"},
+		{"perl", "perl -E say", ""},
+		{"ruby", "ruby -r json -I lib app.rb", "app.rb"},
+		{"node", "node --require ts-node/register server.ts", "server.ts"},
+		{"node", "node -p 1+1", ""},
+		{"bash", "bash -o pipefail job.sh", "job.sh"},
+		{"Rscript", "Rscript -e q()", ""},
+		{"Rscript", "Rscript --vanilla model.R", "model.R"},
+	}
+
+	for _, tt := range tests {
+		if got := scriptName(tt.interpreter, strings.Fields(tt.cmdline)); got != tt.want {
+			t.Errorf("scriptName(%q, %q) = %q, want %q", tt.interpreter, tt.cmdline, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// GroupUsage is the usage of a single cgroup as of one collection.
+// Processes are keyed by name, and are not subject to ProcessLimit.
+type GroupUsage struct {
+	CGroup    string
+	Time      time.Time
+	Info      hierarchy.CGroupInfo
+	Processes map[string]ProcessAggregation
+}
+
+// Observer is notified with the usage of every cgroup collected once a
+// collection completes. Observe is called synchronously from the collection,
+// so it should return quickly.
+type Observer interface {
+	Observe(usage []GroupUsage)
+}
+
+var observers struct {
+	list  []Observer
+	mutex sync.Mutex
+}
+
+// RegisterObserver adds o to the observers notified after each collection.
+func RegisterObserver(o Observer) {
+	defer observers.mutex.Unlock()
+	observers.mutex.Lock()
+	observers.list = append(observers.list, o)
+}
+
+func notify(usage []GroupUsage) {
+	defer observers.mutex.Unlock()
+	observers.mutex.Lock()
+	for _, o := range observers.list {
+		o.Observe(usage)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// units inferred from the suffix of a metric name
+var unitSuffixes = []string{"bytes", "seconds"}
+
+// openMetricsHandler serves g through promhttp, negotiating OpenMetrics with
+// _created samples for scrapers that request it, and compressing responses
+// for those that accept it.
+func openMetricsHandler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(unitGatherer{g}, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
+}
+
+// unitGatherer sets the unit of every metric family gathered, which is
+// carried by the protobuf format.
+type unitGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		setUnit(mf)
+	}
+	return mfs, err
+}
+
+// setUnit sets the unit of mf if its name ends in a known unit, ignoring the
+// _total suffix of counters.
+func setUnit(mf *dto.MetricFamily) {
+	name := strings.TrimSuffix(mf.GetName(), "_total")
+	for _, unit := range unitSuffixes {
+		if strings.HasSuffix(name, "_"+unit) {
+			mf.Unit = &unit
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type createdCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c createdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c createdCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- counter(c.desc, 42, time.Unix(1700000000, 0), "/user.slice/user-1000.slice")
+}
+
+func TestOpenMetricsHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(createdCollector{
+		desc: prometheus.NewDesc("cgroup_warden_cpu_usage_seconds_total", "Total CPU usage in seconds", []string{"cgroup"}, nil),
+	})
+	handler := openMetricsHandler(registry)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/openmetrics-text") {
+		t.Fatalf("got content type %q, want OpenMetrics", got)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got content encoding %q, want gzip", got)
+	}
+
+	r, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "cgroup_warden_cpu_usage_seconds_created{cgroup=\"/user.slice/user-1000.slice\"} 1.7e+09") {
+		t.Errorf("_created sample missing from:\n%s", body)
+	}
+
+	// scrapers that do not negotiate OpenMetrics get the text format
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("got content type %q, want text/plain", got)
+	}
+}
+
+func TestUnitGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(createdCollector{
+		desc: prometheus.NewDesc("cgroup_warden_cpu_usage_seconds_total", "Total CPU usage in seconds", []string{"cgroup"}, nil),
+	})
+
+	mfs, err := unitGatherer{registry}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].GetUnit() != "seconds" {
+		t.Errorf("got %v, want the unit set to seconds", mfs)
+	}
+}
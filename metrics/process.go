@@ -1,24 +1,132 @@
 package metrics
 
 import (
+	"context"
 	"sync"
+	"time"
 
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
 	"github.com/prometheus/procfs"
 )
 
+// PSS is expensive to read, as the kernel walks every mapping of the process
+// while holding its mmap_lock. PSSEnabled turns collection off entirely, and
+// PSSInterval reuses a cached value until it is older than the interval.
+var (
+	PSSEnabled  = true
+	PSSInterval time.Duration
+)
+
+// ProcessRetention is how long the counters of exited processes are kept
+// after the last process with the same name was seen, so that the
+// cumulative counters of a name do not reset while it is in use.
+var ProcessRetention = time.Hour
+
+// processKey identifies a process across PID reuse.
+type processKey struct {
+	pid       uint64
+	starttime uint64
+}
+
+// counters are the cumulative usage of a process, which are carried over to
+// the totals of its name once it exits.
+type counters struct {
+	cpuSeconds             float64
+	readBytes              uint64
+	writeBytes             uint64
+	voluntaryCtxSwitches   uint64
+	involuntaryCtxSwitches uint64
+}
+
+func (c *counters) add(other counters) {
+	c.cpuSeconds += other.cpuSeconds
+	c.readBytes += other.readBytes
+	c.writeBytes += other.writeBytes
+	c.voluntaryCtxSwitches += other.voluntaryCtxSwitches
+	c.involuntaryCtxSwitches += other.involuntaryCtxSwitches
+}
+
+// since returns the usage counted by c after base.
+func (c counters) since(base counters) counters {
+	return counters{
+		cpuSeconds:             max(c.cpuSeconds-base.cpuSeconds, 0),
+		readBytes:              c.readBytes - min(base.readBytes, c.readBytes),
+		writeBytes:             c.writeBytes - min(base.writeBytes, c.writeBytes),
+		voluntaryCtxSwitches:   c.voluntaryCtxSwitches - min(base.voluntaryCtxSwitches, c.voluntaryCtxSwitches),
+		involuntaryCtxSwitches: c.involuntaryCtxSwitches - min(base.involuntaryCtxSwitches, c.involuntaryCtxSwitches),
+	}
+}
+
+// switches are the context switch counts of a thread.
+type switches struct {
+	voluntary   uint64
+	involuntary uint64
+}
+
 type process struct {
-	cpuSeconds  float64
+	counters
 	memoryBytes uint64
 	memoryPSS   uint64
+	memorySwap  uint64
+	pssTime     time.Time
+	threads     uint64
+	fds         uint64
 	command     string
 	current     bool
+
+	// the counters of the process when it last exec'd into command, which
+	// were counted under its previous name
+	base counters
+
+	// the context switches of the live threads of the process by tid, and
+	// those of its threads that have exited, which the kernel does not report
+	tasks       map[int]switches
+	exitedTasks switches
+}
+
+// exited holds the accumulated counters of exited processes with a name.
+type exited struct {
+	counters
+	lastSeen time.Time
 }
 
 type ProcessAggregation struct {
-	cpuSecondsTotal  float64
-	memoryBytesTotal uint64
-	memoryPSSTotal   uint64
-	count            uint64
+	CPUSeconds                 float64 `json:"cpu_usage_seconds"`
+	ReadBytes                  uint64  `json:"io_read_bytes"`
+	WriteBytes                 uint64  `json:"io_write_bytes"`
+	VoluntaryContextSwitches   uint64  `json:"voluntary_context_switches"`
+	InvoluntaryContextSwitches uint64  `json:"involuntary_context_switches"`
+	MemoryBytes                uint64  `json:"memory_usage_bytes"`
+	MemoryPSS                  uint64  `json:"memory_pss_bytes"`
+	MemorySwap                 uint64  `json:"memory_swap_bytes"`
+	MemoryPSSAge               float64 `json:"memory_pss_age_seconds"`
+	Threads                    uint64  `json:"threads"`
+	OpenFDs                    uint64  `json:"open_fds"`
+	Count                      uint64  `json:"count"`
+}
+
+func (r *ProcessAggregation) addCounters(c counters) {
+	r.CPUSeconds += c.cpuSeconds
+	r.ReadBytes += c.readBytes
+	r.WriteBytes += c.writeBytes
+	r.VoluntaryContextSwitches += c.voluntaryCtxSwitches
+	r.InvoluntaryContextSwitches += c.involuntaryCtxSwitches
+}
+
+// add folds other into the aggregation.
+func (r *ProcessAggregation) add(other ProcessAggregation) {
+	r.CPUSeconds += other.CPUSeconds
+	r.ReadBytes += other.ReadBytes
+	r.WriteBytes += other.WriteBytes
+	r.VoluntaryContextSwitches += other.VoluntaryContextSwitches
+	r.InvoluntaryContextSwitches += other.InvoluntaryContextSwitches
+	r.MemoryBytes += other.MemoryBytes
+	r.MemoryPSS += other.MemoryPSS
+	r.MemorySwap += other.MemorySwap
+	r.MemoryPSSAge = max(r.MemoryPSSAge, other.MemoryPSSAge)
+	r.Threads += other.Threads
+	r.OpenFDs += other.OpenFDs
+	r.Count += other.Count
 }
 
 type processCache struct {
@@ -39,6 +147,7 @@ func (pc *processCache) get(cgroup string) *entry {
 	value, ok := pc.data[cgroup]
 	if !ok {
 		value = newEntry()
+		pc.data[cgroup] = value
 	}
 	return value
 }
@@ -61,53 +170,130 @@ func (pc *processCache) clean(active map[string]bool) {
 
 func CleanProcessCache(active map[string]bool) {
 	cache.clean(active)
+	cleanSelections(active)
 }
 
 type entry struct {
-	data  map[uint64]process
-	mutex sync.Mutex
+	data   map[processKey]process
+	exited map[string]*exited
+	mutex  sync.Mutex
+
+	// serializes collections of the cgroup, so that an older collection
+	// cannot put back processes a newer one has already retired
+	collecting sync.Mutex
 }
 
 func newEntry() *entry {
 	return &entry{
-		data:  make(map[uint64]process),
-		mutex: sync.Mutex{},
+		data:   make(map[processKey]process),
+		exited: make(map[string]*exited),
+		mutex:  sync.Mutex{},
 	}
 }
 
-func (e *entry) update(processes map[uint64]process) {
+// lookup returns the cached process for key.
+func (e *entry) lookup(key processKey) (process, bool) {
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	process, ok := e.data[key]
+	return process, ok
+}
+
+// update replaces the cached processes with those read at now. Cached
+// processes that are no longer in pids, or whose pid now belongs to a
+// different process, have exited and their counters are retained under their
+// name. Processes still in pids that could not be read are kept, but are not
+// current. A process that exec'd into a different name is retired under its
+// previous name as if it had exited, and counts from there under the new
+// one.
+func (e *entry) update(pids map[uint64]bool, processes map[processKey]process, now time.Time) {
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
-	for pid, process := range processes {
-		e.data[pid] = process
+
+	read := make(map[uint64]bool, len(processes))
+	for key := range processes {
+		read[key.pid] = true
+	}
+
+	for key, process := range e.data {
+		if _, ok := processes[key]; ok {
+			continue
+		}
+
+		if pids[key.pid] && !read[key.pid] {
+			process.current = false
+			e.data[key] = process
+			continue
+		}
+
+		e.retire(process, now)
+		delete(e.data, key)
+	}
+
+	for key, process := range processes {
+		if cached, ok := e.data[key]; ok {
+			if cached.command != process.command {
+				e.retire(cached, now)
+				process.base = cached.counters
+			} else {
+				process.base = cached.base
+			}
+		}
+
+		e.data[key] = process
+		if ex, ok := e.exited[process.command]; ok {
+			ex.lastSeen = now
+		}
 	}
 }
 
-func (e *entry) clean(active map[string]bool) {
+// retire adds the counters of process to those retained under its name. It
+// must be called with the lock held.
+func (e *entry) retire(process process, now time.Time) {
+	ex, ok := e.exited[process.command]
+	if !ok {
+		ex = &exited{}
+		e.exited[process.command] = ex
+	}
+	ex.add(process.counters.since(process.base))
+	ex.lastSeen = now
+}
+
+// clean drops the retained counters of names that have not been seen for
+// longer than ProcessRetention.
+func (e *entry) clean(now time.Time) {
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
-	for pid, process := range e.data {
-		if _, ok := active[process.command]; !ok {
-			delete(e.data, pid)
+	for name, ex := range e.exited {
+		if now.Sub(ex.lastSeen) > ProcessRetention {
+			delete(e.exited, name)
 		}
 	}
 }
 
-func (e *entry) aggregate() map[string]ProcessAggregation {
+func (e *entry) aggregate(now time.Time) map[string]ProcessAggregation {
 	results := make(map[string]ProcessAggregation)
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
-	for pid, process := range e.data {
+	for _, process := range e.data {
 		r := results[process.command]
-		r.cpuSecondsTotal += process.cpuSeconds
+		r.addCounters(process.counters.since(process.base))
 		if process.current {
-			r.memoryBytesTotal += process.memoryBytes
-			r.memoryPSSTotal += process.memoryPSS
-			r.count += 1
+			r.MemoryBytes += process.memoryBytes
+			r.MemoryPSS += process.memoryPSS
+			r.MemorySwap += process.memorySwap
+			r.MemoryPSSAge = max(r.MemoryPSSAge, now.Sub(process.pssTime).Seconds())
+			r.Threads += process.threads
+			r.OpenFDs += process.fds
+			r.Count += 1
 		}
 		results[process.command] = r
-		process.current = false
-		e.data[pid] = process
+	}
+
+	for name, ex := range e.exited {
+		r := results[name]
+		r.addCounters(ex.counters)
+		results[name] = r
 	}
 
 	return results
@@ -115,53 +301,178 @@ func (e *entry) aggregate() map[string]ProcessAggregation {
 
 var cache = newProcessCache()
 
-func ProcessInfo(cg string, pids map[uint64]bool) (map[string]ProcessAggregation, error) {
-	fs, err := procfs.NewDefaultFS()
+func ProcessInfo(ctx context.Context, cg string, pids map[uint64]bool) (map[string]ProcessAggregation, error) {
+	fs, err := procfs.NewFS(hierarchy.ProcPath)
 	if err != nil {
 		return nil, err
 	}
 
-	active := make(map[string]bool)
-	processes := make(map[uint64]process)
-
+	jobs := make(chan uint64, len(pids))
 	for pid := range pids {
+		jobs <- pid
+	}
+	close(jobs)
 
-		proc, err := fs.Proc(int(pid))
-		if err != nil {
-			continue
-		}
+	workers := ProcessWorkers
+	if workers <= 0 || workers > len(pids) {
+		workers = len(pids)
+	}
 
-		command, err := proc.Comm()
-		if err != nil {
-			continue
-		}
+	e := cache.get(cg)
+	defer e.collecting.Unlock()
+	e.collecting.Lock()
 
-		stat, err := proc.Stat()
-		if err != nil {
-			continue
-		}
+	processes := make(map[processKey]process)
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pid := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+
+				key, process, ok := readProcess(fs, pid, e)
+				if !ok {
+					continue
+				}
+
+				mutex.Lock()
+				processes[key] = process
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e.update(pids, processes, now)
+	e.clean(now)
+	results := e.aggregate(now)
+	cache.put(cg, e)
+	return results, nil
+}
+
+// readProcess reads the current usage of pid, returning false if the process
+// has exited or could not be read. PSS is taken from the cached entry for
+// the process when it is recent enough.
+func readProcess(fs procfs.FS, pid uint64, e *entry) (processKey, process, bool) {
+	var key processKey
+
+	proc, err := fs.Proc(int(pid))
+	if err != nil {
+		return key, process{}, false
+	}
+
+	command, err := ProcessName(proc)
+	if err != nil {
+		return key, process{}, false
+	}
+
+	stat, err := proc.Stat()
+	if err != nil {
+		return key, process{}, false
+	}
+
+	key = processKey{pid: pid, starttime: stat.Starttime}
+	p := process{
+		counters:    counters{cpuSeconds: stat.CPUTime()},
+		memoryBytes: uint64(stat.ResidentMemory()),
+		threads:     uint64(stat.NumThreads),
+		command:     command,
+		current:     true,
+	}
 
-		rollup, err := proc.ProcSMapsRollup()
+	cached, cachedOK := e.lookup(key)
+
+	// the remaining fields are best effort, as reading io requires ptrace
+	// access to the process, and any of them can race with its exit. The
+	// counters of a failed read are kept from the cached entry, so they do
+	// not go backwards.
+	if io, err := proc.IO(); err == nil {
+		p.readBytes = io.ReadBytes
+		p.writeBytes = io.WriteBytes
+	} else {
+		p.readBytes = cached.readBytes
+		p.writeBytes = cached.writeBytes
+	}
+
+	if status, err := proc.NewStatus(); err == nil {
+		p.memorySwap = status.VmSwap
+	}
+
+	readSwitches(fs, pid, &p, cached)
+
+	if fds, err := proc.FileDescriptorsLen(); err == nil {
+		p.fds = uint64(fds)
+	}
+
+	if !PSSEnabled {
+		return key, p, true
+	}
+
+	if cachedOK && time.Since(cached.pssTime) < PSSInterval {
+		p.memoryPSS = cached.memoryPSS
+		p.pssTime = cached.pssTime
+		return key, p, true
+	}
+
+	rollup, err := proc.ProcSMapsRollup()
+	if err != nil {
+		return key, process{}, false
+	}
+
+	p.memoryPSS = rollup.Pss
+	p.pssTime = time.Now()
+	return key, p, true
+}
+
+// readSwitches sums the context switches of every thread of pid into p, as
+// the status of the process only counts those of its main thread. The counts
+// of threads that exited since prev, the previous reading of the process,
+// are carried over, so the totals do not decrease when threads exit.
+func readSwitches(fs procfs.FS, pid uint64, p *process, prev process) {
+	threads, err := fs.AllThreads(int(pid))
+	if err != nil {
+		p.tasks, p.exitedTasks = prev.tasks, prev.exitedTasks
+		p.voluntaryCtxSwitches = prev.voluntaryCtxSwitches
+		p.involuntaryCtxSwitches = prev.involuntaryCtxSwitches
+		return
+	}
+
+	p.tasks = make(map[int]switches, len(threads))
+	for _, thread := range threads {
+		status, err := thread.NewStatus()
 		if err != nil {
 			continue
 		}
-
-		process := process{
-			cpuSeconds:  stat.CPUTime(),
-			memoryBytes: uint64(stat.ResidentMemory()),
-			memoryPSS:   rollup.Pss,
-			command:     command,
-			current:     true,
+		p.tasks[thread.PID] = switches{
+			voluntary:   status.VoluntaryCtxtSwitches,
+			involuntary: status.NonVoluntaryCtxtSwitches,
 		}
+	}
 
-		active[command] = true
-		processes[pid] = process
+	p.exitedTasks = prev.exitedTasks
+	for tid, last := range prev.tasks {
+		// a tid counting less than before was reused by a new thread
+		if now, ok := p.tasks[tid]; ok && now.voluntary >= last.voluntary && now.involuntary >= last.involuntary {
+			continue
+		}
+		p.exitedTasks.voluntary += last.voluntary
+		p.exitedTasks.involuntary += last.involuntary
 	}
 
-	e := cache.get(cg)
-	e.update(processes)
-	e.clean(active)
-	results := e.aggregate()
-	cache.put(cg, e)
-	return results, nil
+	p.voluntaryCtxSwitches = p.exitedTasks.voluntary
+	p.involuntaryCtxSwitches = p.exitedTasks.involuntary
+	for _, t := range p.tasks {
+		p.voluntaryCtxSwitches += t.voluntary
+		p.involuntaryCtxSwitches += t.involuntary
+	}
 }
@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/procfs"
+)
+
+func testProcess(command string, cpu float64) process {
+	return process{
+		counters:    counters{cpuSeconds: cpu, readBytes: uint64(cpu * 100)},
+		memoryBytes: 1024,
+		command:     command,
+		current:     true,
+	}
+}
+
+func TestEntryLastProcessExits(t *testing.T) {
+	e := newEntry()
+	now := time.Now()
+
+	a := processKey{pid: 100, starttime: 1}
+	b := processKey{pid: 101, starttime: 2}
+	e.update(map[uint64]bool{100: true, 101: true}, map[processKey]process{
+		a: testProcess("python", 3),
+		b: testProcess("python", 4),
+	}, now)
+
+	got := e.aggregate(now)["python"]
+	if got.CPUSeconds != 7 || got.Count != 2 {
+		t.Fatalf("before exit: got cpu %v count %d, want 7 and 2", got.CPUSeconds, got.Count)
+	}
+
+	// both processes exit, the counters of the name must not go backwards
+	now = now.Add(time.Minute)
+	e.update(map[uint64]bool{}, map[processKey]process{}, now)
+
+	got = e.aggregate(now)["python"]
+	if got.CPUSeconds != 7 || got.ReadBytes != 700 {
+		t.Errorf("after exit: got cpu %v read %d, want 7 and 700", got.CPUSeconds, got.ReadBytes)
+	}
+	if got.Count != 0 || got.MemoryBytes != 0 {
+		t.Errorf("after exit: got count %d memory %d, want 0 and 0", got.Count, got.MemoryBytes)
+	}
+
+	// a new process with the same name adds to the retained counters
+	now = now.Add(time.Minute)
+	c := processKey{pid: 102, starttime: 3}
+	e.update(map[uint64]bool{102: true}, map[processKey]process{c: testProcess("python", 1)}, now)
+
+	got = e.aggregate(now)["python"]
+	if got.CPUSeconds != 8 || got.Count != 1 {
+		t.Errorf("after restart: got cpu %v count %d, want 8 and 1", got.CPUSeconds, got.Count)
+	}
+}
+
+func TestEntryPIDReuse(t *testing.T) {
+	e := newEntry()
+	now := time.Now()
+
+	old := processKey{pid: 200, starttime: 10}
+	e.update(map[uint64]bool{200: true}, map[processKey]process{old: testProcess("make", 5)}, now)
+
+	// the pid is reused by a different process, which has its own counters
+	now = now.Add(time.Second)
+	reused := processKey{pid: 200, starttime: 20}
+	e.update(map[uint64]bool{200: true}, map[processKey]process{reused: testProcess("gcc", 2)}, now)
+
+	results := e.aggregate(now)
+	if got := results["make"]; got.CPUSeconds != 5 || got.Count != 0 {
+		t.Errorf("make: got cpu %v count %d, want 5 and 0", got.CPUSeconds, got.Count)
+	}
+	if got := results["gcc"]; got.CPUSeconds != 2 || got.Count != 1 {
+		t.Errorf("gcc: got cpu %v count %d, want 2 and 1", got.CPUSeconds, got.Count)
+	}
+
+	// the same process read again replaces its counters rather than adding
+	now = now.Add(time.Second)
+	e.update(map[uint64]bool{200: true}, map[processKey]process{reused: testProcess("gcc", 3)}, now)
+
+	if got := e.aggregate(now)["gcc"]; got.CPUSeconds != 3 {
+		t.Errorf("gcc: got cpu %v, want 3", got.CPUSeconds)
+	}
+}
+
+func TestEntryExec(t *testing.T) {
+	e := newEntry()
+	now := time.Now()
+
+	key := processKey{pid: 250, starttime: 1}
+	e.update(map[uint64]bool{250: true}, map[processKey]process{key: testProcess("bash", 4)}, now)
+
+	// the process execs, keeping its pid and start time but not its name
+	now = now.Add(time.Second)
+	e.update(map[uint64]bool{250: true}, map[processKey]process{key: testProcess("python3", 6)}, now)
+
+	results := e.aggregate(now)
+	if got := results["bash"]; got.CPUSeconds != 4 || got.Count != 0 {
+		t.Errorf("bash: got cpu %v count %d, want 4 and 0", got.CPUSeconds, got.Count)
+	}
+	if got := results["python3"]; got.CPUSeconds != 2 || got.ReadBytes != 200 || got.Count != 1 {
+		t.Errorf("python3: got cpu %v read %d count %d, want 2, 200 and 1", got.CPUSeconds, got.ReadBytes, got.Count)
+	}
+
+	// later readings count from the exec, and the baseline is retired with
+	// the process when it exits
+	now = now.Add(time.Second)
+	e.update(map[uint64]bool{250: true}, map[processKey]process{key: testProcess("python3", 9)}, now)
+	if got := e.aggregate(now)["python3"]; got.CPUSeconds != 5 {
+		t.Errorf("python3: got cpu %v, want 5", got.CPUSeconds)
+	}
+
+	e.update(map[uint64]bool{}, map[processKey]process{}, now)
+	results = e.aggregate(now)
+	if results["bash"].CPUSeconds != 4 || results["python3"].CPUSeconds != 5 {
+		t.Errorf("after exit: got bash %v python3 %v, want 4 and 5", results["bash"].CPUSeconds, results["python3"].CPUSeconds)
+	}
+}
+
+func TestEntryUnreadProcessKept(t *testing.T) {
+	e := newEntry()
+	now := time.Now()
+
+	key := processKey{pid: 300, starttime: 1}
+	e.update(map[uint64]bool{300: true}, map[processKey]process{key: testProcess("sleep", 1)}, now)
+
+	// the process is still in the cgroup but could not be read
+	e.update(map[uint64]bool{300: true}, map[processKey]process{}, now)
+
+	got := e.aggregate(now)["sleep"]
+	if got.CPUSeconds != 1 || got.Count != 0 {
+		t.Errorf("got cpu %v count %d, want 1 and 0", got.CPUSeconds, got.Count)
+	}
+	if len(e.exited) != 0 {
+		t.Errorf("unread process was retired as exited")
+	}
+}
+
+func TestEntryRetentionExpiry(t *testing.T) {
+	defer func(retention time.Duration) { ProcessRetention = retention }(ProcessRetention)
+	ProcessRetention = time.Hour
+
+	e := newEntry()
+	now := time.Now()
+
+	key := processKey{pid: 400, starttime: 1}
+	e.update(map[uint64]bool{400: true}, map[processKey]process{key: testProcess("vim", 2)}, now)
+	e.update(map[uint64]bool{}, map[processKey]process{}, now)
+
+	e.clean(now.Add(ProcessRetention))
+	if got := e.aggregate(now)["vim"]; got.CPUSeconds != 2 {
+		t.Fatalf("within retention: got cpu %v, want 2", got.CPUSeconds)
+	}
+
+	later := now.Add(ProcessRetention + time.Second)
+	e.clean(later)
+	if _, ok := e.aggregate(later)["vim"]; ok {
+		t.Errorf("counters of vim were kept past the retention window")
+	}
+}
+
+func TestEntryRetentionExtendedWhileAlive(t *testing.T) {
+	defer func(retention time.Duration) { ProcessRetention = retention }(ProcessRetention)
+	ProcessRetention = time.Hour
+
+	e := newEntry()
+	now := time.Now()
+
+	a := processKey{pid: 500, starttime: 1}
+	b := processKey{pid: 501, starttime: 2}
+	e.update(map[uint64]bool{500: true, 501: true}, map[processKey]process{
+		a: testProcess("bash", 1),
+		b: testProcess("bash", 1),
+	}, now)
+
+	// one process exits, the other keeps the name in use past the window
+	e.update(map[uint64]bool{501: true}, map[processKey]process{b: testProcess("bash", 2)}, now)
+	later := now.Add(2 * ProcessRetention)
+	e.update(map[uint64]bool{501: true}, map[processKey]process{b: testProcess("bash", 3)}, later)
+	e.clean(later)
+
+	if got := e.aggregate(later)["bash"]; got.CPUSeconds != 4 {
+		t.Errorf("got cpu %v, want 4", got.CPUSeconds)
+	}
+}
+
+func TestReadSwitchesThreadExit(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, 100, "matlab")
+
+	writeTask := func(tid int, voluntary, involuntary int) {
+		dir := path.Join(root, "100", "task", fmt.Sprint(tid))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		status := fmt.Sprintf("Name:\tmatlab\nvoluntary_ctxt_switches:\t%d\nnonvoluntary_ctxt_switches:\t%d\n", voluntary, involuntary)
+		if err := os.WriteFile(path.Join(dir, "status"), []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTask(101, 5, 1)
+
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first process
+	readSwitches(fs, 100, &first, process{})
+	if first.voluntaryCtxSwitches != 15 || first.involuntaryCtxSwitches != 3 {
+		t.Fatalf("got %d and %d, want 15 and 3", first.voluntaryCtxSwitches, first.involuntaryCtxSwitches)
+	}
+
+	// the second thread exits, and the main thread switches twice more
+	if err := os.RemoveAll(path.Join(root, "100", "task", "101")); err != nil {
+		t.Fatal(err)
+	}
+	writeTask(100, 12, 2)
+
+	var second process
+	readSwitches(fs, 100, &second, first)
+	if second.voluntaryCtxSwitches != 17 || second.involuntaryCtxSwitches != 3 {
+		t.Errorf("got %d and %d, want 17 and 3", second.voluntaryCtxSwitches, second.involuntaryCtxSwitches)
+	}
+}
+
+func TestReadProcessIOUnreadable(t *testing.T) {
+	root := t.TempDir()
+	saved := hierarchy.ProcPath
+	hierarchy.ProcPath = root
+	defer func() { hierarchy.ProcPath = saved }()
+
+	cg := "/io-unreadable.slice"
+	defer CleanProcessCache(nil)
+
+	writeProc(t, root, 100, "rsync")
+	pids := map[uint64]bool{100: true}
+	if _, err := ProcessInfo(context.Background(), cg, pids); err != nil {
+		t.Fatal(err)
+	}
+
+	// io can no longer be read, as when ptrace access is lost
+	if err := os.Remove(path.Join(root, "100", "io")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := ProcessInfo(context.Background(), cg, pids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results["rsync"]; got.ReadBytes != 4096 || got.WriteBytes != 8192 {
+		t.Errorf("got read %d write %d, want 4096 and 8192", got.ReadBytes, got.WriteBytes)
+	}
+}
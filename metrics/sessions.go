@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/login1"
+)
+
+// SessionsEnabled enables labelling the session scopes of each user with
+// their logind session, and exporting the number of sessions per user.
+var SessionsEnabled = false
+
+// Session is a logind login session, which runs in a scope under the slice
+// of its user.
+type Session struct {
+	ID         string    `json:"id"`
+	UID        string    `json:"uid"`
+	Scope      string    `json:"scope"`
+	Service    string    `json:"service"`
+	TTY        string    `json:"tty"`
+	RemoteHost string    `json:"remote_host"`
+	Start      time.Time `json:"start"`
+}
+
+// the properties of a session do not change, so they are only requested
+// from logind once per session
+var sessionCache = struct {
+	data  map[string]Session
+	mutex sync.Mutex
+}{data: make(map[string]Session)}
+
+// listSessions returns the current logind sessions keyed by uid.
+func listSessions(ctx context.Context) (map[string][]Session, error) {
+	conn, err := login1.New()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	list, err := conn.ListSessionsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer sessionCache.mutex.Unlock()
+	sessionCache.mutex.Lock()
+
+	current := make(map[string]Session, len(list))
+	sessions := make(map[string][]Session)
+	for _, s := range list {
+		session, ok := sessionCache.data[s.ID]
+		if !ok {
+			props, err := conn.GetSessionPropertiesContext(ctx, s.Path)
+			if err != nil {
+				// the session ended after it was listed
+				continue
+			}
+
+			session = Session{
+				ID:         s.ID,
+				UID:        strconv.FormatUint(uint64(s.UID), 10),
+				Scope:      stringProperty(props["Scope"].Value()),
+				Service:    stringProperty(props["Service"].Value()),
+				TTY:        stringProperty(props["TTY"].Value()),
+				RemoteHost: stringProperty(props["RemoteHost"].Value()),
+			}
+			if usec, ok := props["Timestamp"].Value().(uint64); ok && usec > 0 {
+				session.Start = time.UnixMicro(int64(usec))
+			}
+		}
+
+		current[s.ID] = session
+		sessions[session.UID] = append(sessions[session.UID], session)
+	}
+
+	sessionCache.data = current
+	return sessions, nil
+}
+
+func stringProperty(value any) string {
+	s, _ := value.(string)
+	return s
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Snapshotter collects metrics on a fixed interval in the background, so
+// that scrapes are served from the most recent snapshot instead of each
+// walking every cgroup and process.
+type Snapshotter struct {
+	collector *Collector
+	interval  time.Duration
+	current   atomic.Pointer[snapshot]
+	duration  prometheus.Gauge
+	errors    prometheus.Counter
+	age       prometheus.GaugeFunc
+}
+
+// snapshot is the immutable result of a single collection.
+type snapshot struct {
+	metrics    []prometheus.Metric
+	collection collection
+}
+
+func NewSnapshotter(root string, interval time.Duration) *Snapshotter {
+	s := &Snapshotter{
+		collector: NewCollector(root),
+		interval:  interval,
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "snapshot",
+			Name:      "duration_seconds",
+			Help:      "Time taken by the most recent background collection in seconds",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "snapshot",
+			Name:      "errors_total",
+			Help:      "Number of background collections that failed",
+		}),
+	}
+
+	s.age = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "snapshot",
+		Name:      "age_seconds",
+		Help:      "Time since the snapshot being served was taken in seconds",
+	}, func() float64 {
+		snap := s.current.Load()
+		if snap == nil {
+			return -1
+		}
+		return time.Since(snap.collection.time).Seconds()
+	})
+
+	return s
+}
+
+// Run collects a snapshot immediately, then once every interval until ctx
+// is cancelled.
+func (s *Snapshotter) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Snapshotter) update(ctx context.Context) {
+	start := time.Now()
+
+	ch := make(chan prometheus.Metric)
+	var result collection
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		result, err = s.collector.collect(ctx, ch)
+		close(ch)
+		done <- err
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	err := <-done
+	s.duration.Set(time.Since(start).Seconds())
+	if err != nil {
+		// keep serving the previous snapshot rather than an empty one
+		slog.Error("could not collect cgroups with pids", "err", err)
+		s.errors.Inc()
+		return
+	}
+
+	s.current.Store(&snapshot{metrics: metrics, collection: result})
+}
+
+// status returns the collection of the current snapshot.
+func (s *Snapshotter) status(ctx context.Context) (collection, error) {
+	snap := s.current.Load()
+	if snap == nil {
+		return collection{}, errors.New("no snapshot has been collected yet")
+	}
+	return snap.collection, nil
+}
+
+func (s *Snapshotter) Describe(ch chan<- *prometheus.Desc) {
+	s.collector.Describe(ch)
+	s.duration.Describe(ch)
+	s.errors.Describe(ch)
+	s.age.Describe(ch)
+}
+
+func (s *Snapshotter) Collect(ch chan<- prometheus.Metric) {
+	if snap := s.current.Load(); snap != nil {
+		for _, m := range snap.metrics {
+			ch <- m
+		}
+	}
+	s.duration.Collect(ch)
+	s.errors.Collect(ch)
+	s.age.Collect(ch)
+}
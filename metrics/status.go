@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type processStatus struct {
+	ProcessAggregation
+	Name string `json:"name"`
+}
+
+type cgroupStatus struct {
+	CGroup           string          `json:"cgroup"`
+	CPUUsageSeconds  float64         `json:"cpu_usage_seconds"`
+	CPUQuota         int64           `json:"cpu_quota"`
+	MemoryUsageBytes uint64          `json:"memory_usage_bytes"`
+	MemoryMax        float64         `json:"memory_max"`
+	SwapUsageBytes   uint64          `json:"swap_usage_bytes"`
+	SwapMax          float64         `json:"swap_max"`
+	Frozen           bool            `json:"frozen"`
+	FoldedProcesses  int             `json:"folded_processes"`
+	Processes        []processStatus `json:"processes"`
+}
+
+type userStatus struct {
+	Username string         `json:"username"`
+	UID      string         `json:"uid"`
+	GID      string         `json:"gid"`
+	Group    string         `json:"group"`
+	Groups   []string       `json:"groups"`
+	CGroups  []cgroupStatus `json:"cgroups"`
+}
+
+type statusResponse struct {
+	Time    time.Time    `json:"time"`
+	Partial bool         `json:"partial"`
+	Users   []userStatus `json:"users"`
+}
+
+// StatusHandler runs c on each request, and serves the usage collected as
+// JSON, grouped by user, cgroup and process.
+func StatusHandler(c *Collector) http.Handler {
+	return statusHandler(c.status)
+}
+
+// SnapshotStatusHandler serves the usage of the most recent snapshot taken
+// by s as JSON, grouped by user, cgroup and process.
+func SnapshotStatusHandler(s *Snapshotter) http.Handler {
+	return statusHandler(s.status)
+}
+
+func statusHandler(status func(context.Context) (collection, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := status(r.Context())
+		if err != nil {
+			slog.Error("unable to collect status", "err", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newStatusResponse(result))
+	}
+}
+
+// newStatusResponse structures a collection by user. Limits are reported as
+// they are in the metrics, with -1 meaning unlimited, and processes are
+// subject to the same ProcessLimit.
+func newStatusResponse(result collection) statusResponse {
+	users := make(map[string]*userStatus)
+	for _, u := range result.usage {
+		info := u.Info
+		user, ok := users[info.Username]
+		if !ok {
+			user = &userStatus{
+				Username: info.Username,
+				UID:      info.UID,
+				GID:      info.GID,
+				Group:    info.Group,
+				Groups:   info.Groups,
+			}
+			users[info.Username] = user
+		}
+
+		procs, folded := limitProcesses(u.Processes, currentSelection(u.CGroup))
+		cg := cgroupStatus{
+			CGroup:           u.CGroup,
+			CPUUsageSeconds:  info.CPUUsage,
+			CPUQuota:         info.CPUQuota,
+			MemoryUsageBytes: info.MemoryUsage,
+			MemoryMax:        negativeOneIfMax(info.MemoryMax),
+			SwapUsageBytes:   info.SwapUsage,
+			SwapMax:          negativeOneIfMax(info.SwapMax),
+			Frozen:           info.Frozen,
+			FoldedProcesses:  folded,
+			Processes:        make([]processStatus, 0, len(procs)),
+		}
+		for name, p := range procs {
+			cg.Processes = append(cg.Processes, processStatus{ProcessAggregation: p, Name: name})
+		}
+		slices.SortFunc(cg.Processes, func(a, b processStatus) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+
+		user.CGroups = append(user.CGroups, cg)
+	}
+
+	response := statusResponse{
+		Time:    result.time,
+		Partial: result.partial,
+		Users:   make([]userStatus, 0, len(users)),
+	}
+	for _, user := range users {
+		slices.SortFunc(user.CGroups, func(a, b cgroupStatus) int {
+			return cmp.Compare(a.CGroup, b.CGroup)
+		})
+		response.Users = append(response.Users, *user)
+	}
+	slices.SortFunc(response.Users, func(a, b userStatus) int {
+		return cmp.Compare(a.Username, b.Username)
+	})
+
+	return response
+}
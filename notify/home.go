@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// writeHomeFile writes msg to the file name in the home directory of u,
+// owned by u. As the home directory is writable by the user, the file is
+// never followed if it is a link, and only a regular file with a single
+// link is replaced.
+func writeHomeFile(u *user.User, name string, msg []byte) error {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(u.HomeDir, name)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() || stat.Nlink != 1 {
+		return fmt.Errorf("refusing to write to '%s', which is not a regular file", file)
+	}
+	if stat.Uid != 0 && int(stat.Uid) != uid {
+		return fmt.Errorf("refusing to write to '%s', which is owned by another user", file)
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	return f.Chown(uid, gid)
+}
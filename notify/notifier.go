@@ -0,0 +1,254 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/events"
+)
+
+// Terminals enables writing notices to the terminals of the user. If File is
+// set, notices are also written to a file of that name in the home directory
+// of the user. A user is notified at most once per Interval.
+var (
+	Terminals bool
+	File      string
+	Interval  = 15 * time.Minute
+)
+
+// DefaultTemplate is the notice used when no template is configured.
+const DefaultTemplate = `Message from cgroup-warden on {{.Host}} at {{.Time.Format "15:04"}}:
+{{- if eq .Property "Frozen"}}
+Your processes on this node have been {{if eq .Value "true"}}paused. Please contact support.{{else}}resumed.{{end}}
+{{- else if eq .Property "Signal"}}
+Some of your processes on this node were sent {{.Value}} by the administrators.
+{{- else}}
+The {{.Property}} limit of your processes on this node has been set to {{.Value}}.
+Processes using more than the limit may slow down or be stopped.
+{{- end}}
+`
+
+// Notice is the data a template is executed with.
+type Notice struct {
+	Username string
+	Host     string
+	Time     time.Time
+	Unit     string
+	Property string
+	Value    string
+}
+
+// Notifier tells users when a limit has been applied to them.
+type Notifier struct {
+	broker   *events.Broker
+	template *template.Template
+	host     string
+	last     map[string]time.Time
+	mutex    sync.Mutex
+}
+
+// NewNotifier creates a notifier for the limits applied through broker,
+// rendering notices with the template at path, or DefaultTemplate if path is
+// empty.
+func NewNotifier(b *events.Broker, path string) (*Notifier, error) {
+	text := DefaultTemplate
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(buf)
+	}
+
+	t, err := template.New("notice").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse notice template: %w", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{
+		broker:   b,
+		template: t,
+		host:     host,
+		last:     make(map[string]time.Time),
+		mutex:    sync.Mutex{},
+	}, nil
+}
+
+// Run notifies users of the limits applied to them until ctx is done.
+func (n *Notifier) Run(ctx context.Context) {
+	subscription, cancel := n.broker.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-subscription:
+			if !ok {
+				return
+			}
+			if e.Type != events.ControlApplied || e.Username == "" || !restricts(e) {
+				continue
+			}
+
+			// a user must always learn their processes were paused or
+			// resumed, even shortly after another notice
+			frozen := e.Data["property"] == control.Frozen
+			if !n.allow(e.Username, e.Time, frozen) {
+				slog.Debug("user was notified recently, skipping notice", "username", e.Username)
+				continue
+			}
+			go n.notify(ctx, e)
+		}
+	}
+}
+
+// restricted are the properties that restrict a user when set, and that the
+// user is told about. Accounting and memory protection only benefit them.
+var restricted = []string{
+	control.CPUQuotaPerSecUSec,
+	control.MemoryMax,
+	control.MemorySwapMax,
+	control.MemoryHigh,
+	control.Frozen,
+	control.Signal,
+}
+
+// restricts reports whether the control event e restricts the user, rather
+// than lifting a limit or changing something they cannot notice. Thawing is
+// included, so that users learn their processes were resumed.
+func restricts(e events.Event) bool {
+	property, _ := e.Data["property"].(string)
+	if !slices.Contains(restricted, property) {
+		return false
+	}
+
+	switch v := e.Data["value"].(type) {
+	case float64:
+		return v >= 0
+	case int64:
+		return v >= 0
+	case int:
+		return v >= 0
+	}
+	return true
+}
+
+// allow reports whether username can be notified at now, recording the
+// notice if so. A forced notice is always allowed.
+func (n *Notifier) allow(username string, now time.Time, force bool) bool {
+	defer n.mutex.Unlock()
+	n.mutex.Lock()
+
+	for name, last := range n.last {
+		if now.Sub(last) >= Interval {
+			delete(n.last, name)
+		}
+	}
+
+	if _, ok := n.last[username]; ok && !force {
+		return false
+	}
+	n.last[username] = now
+	return true
+}
+
+func (n *Notifier) notify(ctx context.Context, e events.Event) {
+	u, err := lookupUser(e.Username)
+	if err != nil {
+		slog.Warn("unable to look up user to notify", "username", e.Username, "err", err)
+		return
+	}
+
+	notice := Notice{
+		Username: e.Username,
+		Host:     n.host,
+		Time:     e.Time,
+		Unit:     e.Unit,
+		Property: fmt.Sprint(e.Data["property"]),
+		Value:    formatValue(e.Data["property"], e.Data["value"]),
+	}
+
+	var buf bytes.Buffer
+	err = n.template.Execute(&buf, notice)
+	if err != nil {
+		slog.Warn("unable to render notice", "username", e.Username, "err", err)
+		return
+	}
+
+	if Terminals {
+		written, err := writeTerminals(ctx, u, buf.Bytes())
+		if err != nil {
+			slog.Warn("unable to notify user on their terminals", "username", e.Username, "err", err)
+		} else {
+			slog.Debug("notified user on their terminals", "username", e.Username, "terminals", written)
+		}
+	}
+
+	if File != "" {
+		err := writeHomeFile(u, File, buf.Bytes())
+		if err != nil {
+			slog.Warn("unable to write notice to home directory", "username", e.Username, "err", err)
+		}
+	}
+}
+
+// lookupUser resolves username, which is the numeric uid when the identity
+// of the user could not be resolved.
+func lookupUser(username string) (*user.User, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if _, parseErr := strconv.Atoi(username); parseErr == nil {
+			return user.LookupId(username)
+		}
+	}
+	return u, err
+}
+
+// formatValue formats the value of a property for people, in bytes for
+// memory limits, as a percentage of a core for CPU quotas, and unlimited
+// for -1.
+func formatValue(property, value any) string {
+	v, ok := value.(float64)
+	if !ok {
+		if i, isInt := value.(int64); isInt {
+			v, ok = float64(i), true
+		}
+	}
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	if v < 0 {
+		return "unlimited"
+	}
+
+	switch property {
+	case control.MemoryMax, control.MemoryHigh, control.MemorySwapMax, control.MemoryLow, control.MemoryMin:
+		units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+		i := 0
+		for v >= 1024 && i < len(units)-1 {
+			v /= 1024
+			i++
+		}
+		return fmt.Sprintf("%.1f %s", v, units[i])
+	case control.CPUQuotaPerSecUSec:
+		return fmt.Sprintf("%.0f%% of a core", v/10000)
+	}
+	return fmt.Sprint(value)
+}
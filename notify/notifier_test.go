@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/events"
+)
+
+func TestRestricts(t *testing.T) {
+	tests := []struct {
+		property string
+		value    any
+		want     bool
+	}{
+		{control.MemoryMax, float64(4 << 30), true},
+		{control.MemoryMax, int64(-1), false},
+		{control.CPUQuotaPerSecUSec, float64(-1), false},
+		{control.MemoryHigh, int64(1 << 30), true},
+		{control.MemoryLow, int64(1 << 30), false},
+		{control.CPUAccounting, true, false},
+		{control.Frozen, true, true},
+		{control.Frozen, false, true},
+		{control.Signal, "SIGTERM", true},
+	}
+
+	for _, test := range tests {
+		e := events.Event{Data: map[string]any{"property": test.property, "value": test.value}}
+		if got := restricts(e); got != test.want {
+			t.Errorf("restricts(%s=%v) = %v, want %v", test.property, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllowForced(t *testing.T) {
+	n := &Notifier{last: make(map[string]time.Time)}
+	now := time.Now()
+
+	if !n.allow("alice", now, false) {
+		t.Fatal("first notice was not allowed")
+	}
+	if n.allow("alice", now.Add(time.Minute), false) {
+		t.Error("second notice within the interval was allowed")
+	}
+	if !n.allow("alice", now.Add(time.Minute), true) {
+		t.Error("forced notice within the interval was not allowed")
+	}
+	if !n.allow("alice", now.Add(time.Minute+Interval), false) {
+		t.Error("notice after the interval was not allowed")
+	}
+}
@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/v22/login1"
+)
+
+const utmpPath = "/var/run/utmp"
+
+// layout of a glibc utmp record
+const (
+	utmpRecordSize  = 384
+	utmpUserProcess = 7
+	utmpLineOffset  = 8
+	utmpLineSize    = 32
+	utmpUserOffset  = 44
+	utmpUserSize    = 32
+)
+
+// writeTerminals writes msg to every terminal the user is logged in on,
+// returning how many were written to. Terminals are found from the logind
+// sessions of the user, or from utmp if logind is unavailable.
+func writeTerminals(ctx context.Context, u *user.User, msg []byte) (int, error) {
+	ttys, err := logindTerminals(ctx, u.Uid)
+	if err != nil {
+		slog.Debug("unable to list logind sessions, falling back to utmp", "err", err)
+		ttys, err = utmpTerminals(u.Username)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// terminals expect carriage returns, and may be in raw mode
+	msg = append([]byte("\r\n"), bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))...)
+
+	written := 0
+	for _, tty := range ttys {
+		err := writeTerminal(tty, u.Uid, msg)
+		if err != nil {
+			slog.Debug("unable to write to terminal", "tty", tty, "username", u.Username, "err", err)
+			continue
+		}
+		written++
+	}
+	return written, nil
+}
+
+// logindTerminals returns the terminals of the sessions of uid.
+func logindTerminals(ctx context.Context, uid string) ([]string, error) {
+	conn, err := login1.New()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sessions, err := conn.ListSessionsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ttys []string
+	for _, session := range sessions {
+		if strconv.FormatUint(uint64(session.UID), 10) != uid {
+			continue
+		}
+
+		value, err := conn.GetSessionPropertyContext(ctx, session.Path, "TTY")
+		if err != nil {
+			continue
+		}
+
+		tty, ok := value.Value().(string)
+		if ok && tty != "" {
+			ttys = append(ttys, tty)
+		}
+	}
+	return ttys, nil
+}
+
+// utmpTerminals returns the terminals username is logged in on according to
+// utmp.
+func utmpTerminals(username string) ([]string, error) {
+	f, err := os.Open(utmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ttys []string
+	record := make([]byte, utmpRecordSize)
+	for {
+		_, err := io.ReadFull(f, record)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ttys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if binary.NativeEndian.Uint16(record) != utmpUserProcess {
+			continue
+		}
+		if cstring(record[utmpUserOffset:utmpUserOffset+utmpUserSize]) != username {
+			continue
+		}
+
+		tty := cstring(record[utmpLineOffset : utmpLineOffset+utmpLineSize])
+		if tty != "" {
+			ttys = append(ttys, tty)
+		}
+	}
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// writeTerminal writes msg to tty, a path relative to /dev. Like write(1),
+// only terminals owned by the user that accept messages are written to, and
+// the write never blocks on a terminal that is not being read.
+func writeTerminal(tty string, uid string, msg []byte) error {
+	file := filepath.Join("/dev", tty)
+	if !strings.HasPrefix(file, "/dev/") {
+		return fmt.Errorf("invalid terminal '%s'", tty)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("'%s' is not a terminal", file)
+	}
+	if strconv.FormatUint(uint64(stat.Uid), 10) != uid {
+		return fmt.Errorf("'%s' is not owned by the user", file)
+	}
+	if fi.Mode().Perm()&0o020 == 0 {
+		return fmt.Errorf("'%s' does not accept messages", file)
+	}
+
+	_, err = f.Write(msg)
+	return err
+}
@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/events"
+)
+
+// headers sent with every delivery
+const (
+	EventHeader     = "X-Cgroup-Warden-Event"
+	DeliveryHeader  = "X-Cgroup-Warden-Delivery"
+	SignatureHeader = "X-Cgroup-Warden-Signature"
+)
+
+// Types are the event types sent to receivers, matched as by events.Matches.
+// If Secret is set, deliveries are signed with an HMAC-SHA256 of the body.
+// Failed deliveries are retried after Backoff, doubling up to MaxBackoff.
+var (
+	Types      = []string{events.ControlApplied, events.MemoryOOMKill}
+	Secret     string
+	QueueSize  = 1000
+	Timeout    = 10 * time.Second
+	Backoff    = time.Second
+	MaxBackoff = 5 * time.Minute
+)
+
+// Payload is the body posted to receivers.
+type Payload struct {
+	ID    string       `json:"id"`
+	Host  string       `json:"host"`
+	Event events.Event `json:"event"`
+}
+
+// errPermanent marks a delivery the receiver rejected, which is not retried.
+var errPermanent = errors.New("delivery rejected")
+
+type target struct {
+	url   string
+	queue *queue
+}
+
+// Notifier posts events published to a broker to every receiver. Each
+// receiver has its own queue, so one that is down does not hold up the
+// others, and deliveries to it are retried in order until it accepts them.
+type Notifier struct {
+	broker  *events.Broker
+	targets []*target
+	client  *http.Client
+	host    string
+	last    int64
+	mutex   sync.Mutex
+}
+
+// NewNotifier creates a notifier posting to urls. If dir is set, undelivered
+// events are queued on disk under it, one directory per receiver.
+func NewNotifier(b *events.Broker, urls []string, dir string) (*Notifier, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{
+		broker: b,
+		client: &http.Client{Timeout: Timeout},
+		host:   host,
+		mutex:  sync.Mutex{},
+	}
+
+	for _, url := range urls {
+		var qdir string
+		if dir != "" {
+			sum := sha256.Sum256([]byte(url))
+			qdir = path.Join(dir, hex.EncodeToString(sum[:8]))
+		}
+
+		q, err := newQueue(qdir, QueueSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open webhook queue for '%s': %w", url, err)
+		}
+		n.targets = append(n.targets, &target{url: url, queue: q})
+	}
+
+	return n, nil
+}
+
+// Run queues matching events for every receiver and delivers them until ctx
+// is done.
+func (n *Notifier) Run(ctx context.Context) {
+	for _, t := range n.targets {
+		go n.deliver(ctx, t)
+	}
+
+	subscription, cancel := n.broker.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-subscription:
+			if !ok {
+				return
+			}
+			if !events.Matches(Types, e.Type) {
+				continue
+			}
+
+			d, err := n.delivery(e)
+			if err != nil {
+				slog.Warn("unable to encode webhook payload", "type", e.Type, "err", err)
+				continue
+			}
+
+			for _, t := range n.targets {
+				t.queue.push(d)
+			}
+		}
+	}
+}
+
+// delivery encodes e with a new id. Ids are increasing, so deliveries queued
+// on disk load in order.
+func (n *Notifier) delivery(e events.Event) (delivery, error) {
+	n.mutex.Lock()
+	n.last = max(time.Now().UnixNano(), n.last+1)
+	id := fmt.Sprintf("%020d", n.last)
+	n.mutex.Unlock()
+
+	body, err := json.Marshal(Payload{ID: id, Host: n.host, Event: e})
+	if err != nil {
+		return delivery{}, err
+	}
+	return delivery{ID: id, Type: e.Type, Body: body}, nil
+}
+
+// deliver sends the deliveries queued for t in order, backing off while the
+// receiver is failing.
+func (n *Notifier) deliver(ctx context.Context, t *target) {
+	backoff := Backoff
+
+	for {
+		d, ok := t.queue.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.queue.ready:
+				continue
+			}
+		}
+
+		err := n.send(ctx, t.url, d)
+		switch {
+		case err == nil:
+			slog.Debug("delivered webhook", "url", t.url, "id", d.ID, "type", d.Type)
+			t.queue.remove(d.ID)
+			backoff = Backoff
+			continue
+		case errors.Is(err, errPermanent):
+			slog.Warn("webhook delivery rejected, dropping it", "url", t.url, "id", d.ID, "type", d.Type, "err", err)
+			t.queue.remove(d.ID)
+			continue
+		}
+
+		slog.Warn("webhook delivery failed, retrying", "url", t.url, "id", d.ID, "type", d.Type, "retry", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, MaxBackoff)
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, url string, d delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(d.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cgroup-warden")
+	req.Header.Set(EventHeader, d.Type)
+	req.Header.Set(DeliveryHeader, d.ID)
+	if Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(Secret, d.Body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("receiver responded %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return fmt.Errorf("%w: receiver responded %s", errPermanent, resp.Status)
+	default:
+		return fmt.Errorf("receiver responded %s", resp.Status)
+	}
+}
+
+// Sign returns the signature header value of body, the hex encoded
+// HMAC-SHA256 of body keyed with secret, prefixed with sha256=.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/events"
+)
+
+// receiver is a local HTTP stand-in that answers each delivery with the
+// status returned by respond, recording the requests it was sent.
+type receiver struct {
+	server   *httptest.Server
+	respond  func(attempt int) int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+	mutex    sync.Mutex
+}
+
+func newReceiver(t *testing.T, respond func(attempt int) int) *receiver {
+	r := &receiver{respond: respond}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mutex.Lock()
+		attempt := len(r.requests)
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.times = append(r.times, time.Now())
+		r.mutex.Unlock()
+
+		w.WriteHeader(r.respond(attempt))
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// deliveries returns the ids of the deliveries received, in order.
+func (r *receiver) deliveries() []string {
+	defer r.mutex.Unlock()
+	r.mutex.Lock()
+	var ids []string
+	for _, req := range r.requests {
+		ids = append(ids, req.Header.Get(DeliveryHeader))
+	}
+	return ids
+}
+
+func always(status int) func(int) int {
+	return func(int) int { return status }
+}
+
+// start delivers the queue of the first target of n until the returned
+// function is called, which waits for delivery to stop.
+func start(n *Notifier) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.deliver(ctx, n.targets[0])
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func queueEmpty(n *Notifier) func() bool {
+	return func() bool {
+		_, ok := n.targets[0].queue.peek()
+		return !ok
+	}
+}
+
+func push(t *testing.T, n *Notifier, unit string) delivery {
+	t.Helper()
+	d, err := n.delivery(events.Event{Type: events.ControlApplied, Unit: unit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.targets[0].queue.push(d)
+	return d
+}
+
+func TestNotifierHeaders(t *testing.T) {
+	defer func(secret string) { Secret = secret }(Secret)
+	Secret = "hunter2"
+
+	r := newReceiver(t, always(http.StatusNoContent))
+	n, err := NewNotifier(nil, []string{r.server.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := push(t, n, "user-1000.slice")
+	stop := start(n)
+	waitFor(t, "delivery", queueEmpty(n))
+	stop()
+
+	if len(r.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(r.requests))
+	}
+	req, body := r.requests[0], r.bodies[0]
+	if got := req.Header.Get(EventHeader); got != events.ControlApplied {
+		t.Errorf("got event header %q, want %q", got, events.ControlApplied)
+	}
+	if got := req.Header.Get(DeliveryHeader); got != d.ID {
+		t.Errorf("got delivery header %q, want %q", got, d.ID)
+	}
+	if got, want := req.Header.Get(SignatureHeader), Sign(Secret, body); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, want application/json", got)
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	defer func(backoff, max time.Duration) { Backoff, MaxBackoff = backoff, max }(Backoff, MaxBackoff)
+	Backoff, MaxBackoff = 5*time.Millisecond, time.Second
+
+	for _, status := range []int{http.StatusInternalServerError, http.StatusRequestTimeout, http.StatusTooManyRequests} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			// fail twice, then accept
+			r := newReceiver(t, func(attempt int) int {
+				if attempt < 2 {
+					return status
+				}
+				return http.StatusOK
+			})
+			n, err := NewNotifier(nil, []string{r.server.URL}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := push(t, n, "user-1000.slice")
+			stop := start(n)
+			waitFor(t, "delivery", queueEmpty(n))
+			stop()
+
+			ids := r.deliveries()
+			if len(ids) != 3 || ids[0] != d.ID || ids[2] != d.ID {
+				t.Fatalf("got deliveries %v, want %s three times", ids, d.ID)
+			}
+
+			// the backoff doubles between attempts
+			if gap := r.times[1].Sub(r.times[0]); gap < Backoff {
+				t.Errorf("first retry after %v, want at least %v", gap, Backoff)
+			}
+			if gap := r.times[2].Sub(r.times[1]); gap < 2*Backoff {
+				t.Errorf("second retry after %v, want at least %v", gap, 2*Backoff)
+			}
+		})
+	}
+}
+
+func TestNotifierDropsRejected(t *testing.T) {
+	defer func(backoff time.Duration) { Backoff = backoff }(Backoff)
+	Backoff = time.Hour
+
+	r := newReceiver(t, func(attempt int) int {
+		if attempt == 0 {
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	})
+	n, err := NewNotifier(nil, []string{r.server.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := push(t, n, "user-1000.slice")
+	accepted := push(t, n, "user-1001.slice")
+	stop := start(n)
+	waitFor(t, "delivery", queueEmpty(n))
+	stop()
+
+	// a retry would wait for the hour long backoff, so the rejected
+	// delivery must have been dropped to reach the next one
+	if ids := r.deliveries(); !slices.Equal(ids, []string{rejected.ID, accepted.ID}) {
+		t.Errorf("got deliveries %v, want %s then %s", ids, rejected.ID, accepted.ID)
+	}
+}
+
+func TestNotifierRedeliversAfterRestart(t *testing.T) {
+	defer func(backoff time.Duration) { Backoff = backoff }(Backoff)
+	Backoff = time.Hour
+
+	dir := t.TempDir()
+
+	// the receiver is down while the first warden queues three deliveries
+	var up atomic.Bool
+	r := newReceiver(t, func(int) int {
+		if up.Load() {
+			return http.StatusNoContent
+		}
+		return http.StatusServiceUnavailable
+	})
+	first, err := NewNotifier(nil, []string{r.server.URL}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, unit := range []string{"user-1000.slice", "user-1001.slice", "user-1002.slice"} {
+		want = append(want, push(t, first, unit).ID)
+	}
+	stop := start(first)
+	waitFor(t, "a failed attempt", func() bool { return len(r.deliveries()) > 0 })
+	stop()
+	if ids := r.deliveries(); len(ids) != 1 || ids[0] != want[0] {
+		t.Fatalf("got deliveries %v while down, want only %s", ids, want[0])
+	}
+
+	// the receiver comes back, and the warden restarts
+	up.Store(true)
+	second, err := NewNotifier(nil, []string{r.server.URL}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop = start(second)
+	waitFor(t, "redelivery", queueEmpty(second))
+	stop()
+
+	if ids := r.deliveries()[1:]; !slices.Equal(ids, want) {
+		t.Errorf("got deliveries %v, want %v", ids, want)
+	}
+
+	entries, err := os.ReadDir(second.targets[0].queue.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d deliveries left on disk, want none", len(entries))
+	}
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// delivery is a payload waiting to be sent to a receiver.
+type delivery struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+// queue holds the deliveries for a single receiver in the order they were
+// pushed. If dir is set, every delivery is also written to a file in dir
+// until it is removed, so deliveries survive restarts of the warden.
+type queue struct {
+	dir     string
+	size    int
+	pending []delivery
+	ready   chan struct{}
+	mutex   sync.Mutex
+}
+
+// newQueue opens the queue in dir, loading the deliveries left in it. An
+// empty dir keeps the queue in memory.
+func newQueue(dir string, size int) (*queue, error) {
+	q := &queue{
+		dir:   dir,
+		size:  size,
+		ready: make(chan struct{}, 1),
+		mutex: sync.Mutex{},
+	}
+
+	if dir == "" {
+		return q, nil
+	}
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// entries are sorted by name, which orders them by id
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		buf, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var d delivery
+		err = json.Unmarshal(buf, &d)
+		if err != nil {
+			slog.Warn("skipping unreadable webhook delivery", "file", entry.Name(), "err", err)
+			continue
+		}
+		q.pending = append(q.pending, d)
+	}
+
+	// the size may have been lowered since the deliveries were written
+	if q.size > 0 && len(q.pending) > q.size {
+		dropped := q.pending[:len(q.pending)-q.size]
+		slog.Warn("webhook queue is over its size, dropping oldest deliveries", "dir", dir, "dropped", len(dropped))
+		for _, d := range dropped {
+			q.unlink(d.ID)
+		}
+		q.pending = slices.Clone(q.pending[len(dropped):])
+	}
+
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+	return q, nil
+}
+
+// push appends d to the queue, dropping the oldest delivery if the queue is
+// full.
+func (q *queue) push(d delivery) {
+	defer q.mutex.Unlock()
+	q.mutex.Lock()
+
+	if q.size > 0 && len(q.pending) >= q.size {
+		oldest := q.pending[0]
+		slog.Warn("webhook queue is full, dropping oldest delivery", "id", oldest.ID, "type", oldest.Type)
+		q.pending = q.pending[1:]
+		q.unlink(oldest.ID)
+	}
+
+	if q.dir != "" {
+		err := q.write(d)
+		if err != nil {
+			slog.Warn("unable to persist webhook delivery, keeping it in memory", "id", d.ID, "err", err)
+		}
+	}
+
+	q.pending = append(q.pending, d)
+	q.signal()
+}
+
+// peek returns the oldest delivery, if any.
+func (q *queue) peek() (delivery, bool) {
+	defer q.mutex.Unlock()
+	q.mutex.Lock()
+	if len(q.pending) == 0 {
+		return delivery{}, false
+	}
+	return q.pending[0], true
+}
+
+// remove drops the delivery with id from the queue.
+func (q *queue) remove(id string) {
+	defer q.mutex.Unlock()
+	q.mutex.Lock()
+	q.pending = slices.DeleteFunc(q.pending, func(d delivery) bool {
+		return d.ID == id
+	})
+	q.unlink(id)
+}
+
+func (q *queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// write stores d in the queue directory, through a temporary file so a
+// partial delivery is never loaded.
+func (q *queue) write(d delivery) error {
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	file := path.Join(q.dir, d.ID+".json")
+	err = os.WriteFile(file+".tmp", buf, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (q *queue) unlink(id string) {
+	if q.dir == "" {
+		return
+	}
+
+	err := os.Remove(path.Join(q.dir, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("unable to remove webhook delivery", "id", id, "err", err)
+	}
+}
@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestNewQueueTrimsToSize(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newQueue(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		q.push(delivery{ID: fmt.Sprintf("%020d", i), Type: "control.applied", Body: []byte("{}")})
+	}
+
+	q, err = newQueue(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.pending) != 2 || q.pending[0].ID != fmt.Sprintf("%020d", 3) {
+		t.Fatalf("got %d deliveries starting at %v, want the newest 2", len(q.pending), q.pending)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d files left in %s, want 2", len(entries), dir)
+	}
+	if _, err := os.Stat(path.Join(dir, fmt.Sprintf("%020d", 0)+".json")); !os.IsNotExist(err) {
+		t.Errorf("oldest delivery was not unlinked")
+	}
+}